@@ -4,49 +4,90 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
 	"strconv"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// Command is a shell command that is executed in the target system.
+type Command struct {
+	// The name or path of the executable.
+	Name string
+	// The arguments of the executable.
+	Args []string
+	// The username of the user that runs the command, empty for the
+	// current user.
+	User string
+	// The working directory of the command.
+	Dir string
+	// Any additional environment variables in "KEY=value" form.
+	Env []string
+	// The maximum duration of the command, zero means no limit.
+	Timeout time.Duration
+}
+
 // ExecuteCommand runs a specific shell command in the target system.
 func ExecuteCommand(cmd string, username string, workDir string, args ...string) (string, error) {
-	command := exec.Command(cmd, args...)
+	return Command{Name: cmd, Args: args, User: username, Dir: workDir}.Execute()
+}
+
+// Execute runs the command and returns its standard output.
+func (c Command) Execute() (string, error) {
+	command := exec.Command(c.Name, c.Args...)
+
+	// Run the command in its own process group, so that a timeout also
+	// kills the processes it spawned, e.g. through "sh -c".
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	var outBuf, errorBuf bytes.Buffer
-	if username != "" {
-		credentials, err := UserCredentialsFromUsername(username)
+	if c.User != "" {
+		credentials, err := UserCredentialsFromUsername(c.User)
 		if err != nil {
 			return "", fmt.Errorf("get user id, gid from username %q: %v",
-				username, err)
+				c.User, err)
 		}
-		groups, err := UserGroupIds(username)
+		groups, err := UserGroupIds(c.User)
 		if err != nil {
 			return "", fmt.Errorf("get user group ids from username %q: %v",
-				username, err)
+				c.User, err)
 		}
-		command.SysProcAttr = &syscall.SysProcAttr{}
 		command.SysProcAttr.Credential = credentials
 		command.SysProcAttr.Credential.Groups = groups
 	}
 
-	command.Env = os.Environ()
-	command.Dir = workDir
+	command.Env = append(os.Environ(), c.Env...)
+	command.Dir = c.Dir
 	command.Stdout = &outBuf
 	command.Stderr = &errorBuf
 
 	if err := command.Start(); err != nil {
 		return "", fmt.Errorf("start command %v: %v: stderr: %s, stdout: %s",
-			cmd, err, errorBuf.String(), outBuf.String())
+			c.Name, err, errorBuf.String(), outBuf.String())
+	}
+
+	var timedOut int32
+	if c.Timeout > 0 {
+		timer := time.AfterFunc(c.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+		})
+		defer timer.Stop()
 	}
 
 	if err := command.Wait(); err != nil {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			err = fmt.Errorf("timed out after %v", c.Timeout)
+		}
 		return "", fmt.Errorf("wait for command %v: %v: stderr: %s, stdout: %s",
-			cmd, err, errorBuf.String(), outBuf.String())
+			c.Name, err, errorBuf.String(), outBuf.String())
 	}
 
 	return outBuf.String(), nil
@@ -120,3 +161,17 @@ func Sha1Hmac(message, secret string) string {
 	h.Write([]byte(message))
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// Sha256Hmac returns the hmac sha256 hash of message m based on secret s.
+func Sha256Hmac(message, secret string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(message))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Sha512Hmac returns the hmac sha512 hash of message m based on secret s.
+func Sha512Hmac(message, secret string) string {
+	h := hmac.New(sha512.New, []byte(secret))
+	h.Write([]byte(message))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/klipitkas/hooktail/common"
 )
@@ -55,6 +56,86 @@ func TestExecuteCommand(t *testing.T) {
 	}
 }
 
+func TestCommandExecute(t *testing.T) {
+
+	type args struct {
+		command common.Command
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			"Test running a command with additional environment",
+			args{
+				command: common.Command{
+					Name: "/bin/sh",
+					Args: []string{"-c", "echo $HOOKTAIL_TEST"},
+					Env:  []string{"HOOKTAIL_TEST=hello"},
+				},
+			},
+			"hello\n",
+			false,
+		},
+		{
+			"Test running a command in a working directory",
+			args{
+				command: common.Command{
+					Name: "pwd",
+					Dir:  "/",
+				},
+			},
+			"/\n",
+			false,
+		},
+		{
+			"Test running a command that exceeds its timeout should fail",
+			args{
+				command: common.Command{
+					Name:    "sleep",
+					Args:    []string{"5"},
+					Timeout: 10 * time.Millisecond,
+				},
+			},
+			"",
+			true,
+		},
+		{
+			"Test a timeout also kills the processes spawned by a shell",
+			args{
+				command: common.Command{
+					Name:    "/bin/sh",
+					Args:    []string{"-c", "sleep 5; echo done"},
+					Timeout: 100 * time.Millisecond,
+				},
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			got, err := tt.args.command.Execute()
+			if tt.args.command.Timeout > 0 && time.Since(start) > time.Second {
+				t.Errorf("took %v, want at most the timeout of %v",
+					time.Since(start), tt.args.command.Timeout)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
 func TestUIDFromUsername(t *testing.T) {
 
 	type args struct {
@@ -218,3 +299,67 @@ func TestSha1Hmac(t *testing.T) {
 		})
 	}
 }
+
+func TestSha256Hmac(t *testing.T) {
+
+	type args struct {
+		message string
+		secret  string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			"Test the sha256 hmac",
+			args{
+				message: "hello world",
+				secret:  "highly-confidential",
+			},
+			"ecbbbe7bb378564551fe391368bffd4f79802fa5433310c17745aed37ee4ae39",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := common.Sha256Hmac(tt.args.message, tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestSha512Hmac(t *testing.T) {
+
+	type args struct {
+		message string
+		secret  string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			"Test the sha512 hmac",
+			args{
+				message: "hello world",
+				secret:  "highly-confidential",
+			},
+			"1ec26c401c2a29a0fa42dc0531c6b60dc34c95c5b8922c3d56d81aed73a9a35b940b43b88d4d9c36b70cc2d1c31e8b31196dd48cccaa22b9e2cbb97db4537cdf",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := common.Sha512Hmac(tt.args.message, tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/klipitkas/hooktail/deployment"
+	"github.com/klipitkas/hooktail/network"
+)
+
+// Check returns every problem of the configuration, such as invalid or
+// duplicate deployments, missing scripts or a missing git command.
+func (config *Config) Check() []error {
+	errs := append([]error{}, config.problems...)
+
+	if _, err := exec.LookPath("git"); err != nil {
+		errs = append(errs, fmt.Errorf("check git command existence: %v", err))
+	}
+
+	networks := []struct {
+		name string
+		list []string
+	}{
+		{"allowed networks", config.AllowedNetworks},
+		{"trusted proxies", config.TrustedProxies},
+	}
+	for _, n := range networks {
+		if _, err := network.ParseNetworks(n.list); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %v: %v", n.name, err))
+		}
+	}
+	if config.GitHubMetaFile != "" {
+		if _, err := network.NewMeta(config.GitHubMetaFile).Hooks(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	seen := map[string]string{}
+	for i, d := range config.Deployments {
+		name := label(i, d)
+		for _, err := range deployment.Check(d) {
+			errs = append(errs, fmt.Errorf("deployment %v: %v", name, err))
+		}
+		key := fmt.Sprintf("%v %v %v %v", d.Repository, d.Branch, d.Tag, d.Path)
+		if other, ok := seen[key]; ok {
+			errs = append(errs, fmt.Errorf("deployment %v: duplicate of deployment %v",
+				name, other))
+			continue
+		}
+		seen[key] = name
+	}
+	return errs
+}
+
+// label returns the name of a deployment for messages, or its
+// repository and position for unnamed deployments, along with the file
+// it is defined in.
+func label(i int, d deployment.Deployment) string {
+	name := d.Name
+	if name == "" {
+		name = fmt.Sprintf("#%d (%v)", i+1, d.Repository)
+	}
+	if d.Source != "" {
+		name += " in " + d.Source
+	}
+	return name
+}
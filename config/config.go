@@ -3,22 +3,62 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"time"
 
 	"github.com/klipitkas/hooktail/deployment"
+	"github.com/klipitkas/hooktail/replay"
 	"gopkg.in/yaml.v2"
 )
 
+// The defaults of the HTTP server, GitHub caps payloads at 25 MB.
+const (
+	DefaultMaxBodySize       int64 = 25 << 20
+	DefaultReadTimeout             = 30 * time.Second
+	DefaultReadHeaderTimeout       = 10 * time.Second
+	DefaultWriteTimeout            = 30 * time.Second
+	DefaultIdleTimeout             = 120 * time.Second
+)
+
 // Config contains all the configuration for the server.
 type Config struct {
 	// The port that the server will listen to.
 	Port int `yaml:"port" json:"port"`
+	// The maximum size of a request body in bytes, larger requests get
+	// a 413. Defaults to DefaultMaxBodySize.
+	MaxBodySize int64 `yaml:"max_body_size,omitempty" json:"max_body_size,omitempty"`
+	// The timeouts of the HTTP server, default to the Default*Timeout
+	// constants.
+	ReadTimeout       time.Duration `yaml:"read_timeout,omitempty" json:"read_timeout,omitempty"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout,omitempty" json:"read_header_timeout,omitempty"`
+	WriteTimeout      time.Duration `yaml:"write_timeout,omitempty" json:"write_timeout,omitempty"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+	// The networks that requests are accepted from, defaults to any.
+	AllowedNetworks []string `yaml:"allowed_networks,omitempty" json:"allowed_networks,omitempty"`
+	// The local copy of the GitHub meta API response, whose hook ranges
+	// are also allowed. It is read again when the file changes.
+	GitHubMetaFile string `yaml:"github_meta_file,omitempty" json:"github_meta_file,omitempty"`
+	// The proxies whose X-Forwarded-For and X-Real-IP headers are trusted.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty" json:"trusted_proxies,omitempty"`
+	// The interval at which the configuration file is checked for changes
+	// to reload it, it is only reloaded on SIGHUP when it is not set.
+	WatchInterval time.Duration `yaml:"watch_interval,omitempty" json:"watch_interval,omitempty"`
+	// The replay protection, which rejects repeated deliveries.
+	Replay replay.Config `yaml:"replay,omitempty" json:"replay,omitempty"`
+	// The globs of the files with more deployments, e.g. "conf.d/*.yml",
+	// relative to the directory of the configuration file.
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
 	// The list of deployments.
 	Deployments []deployment.Deployment `yaml:"deployments,omitempty" json:"deployments,omitempty"`
+
+	// The problems found while parsing, reported by Check.
+	problems []error
 }
 
 // Parse parses a YAML file that contains the configuration
 // and returns a Config struct as the result if the
-// parsing is successful.
+// parsing is successful. Problems such as duplicate names or
+// missing secrets are reported by Check, which must pass before
+// the configuration is used.
 func Parse(config *Config, configPath string) error {
 	// Read configuration
 	b, err := ioutil.ReadFile(configPath)
@@ -29,5 +69,59 @@ func Parse(config *Config, configPath string) error {
 	if err := yaml.Unmarshal(b, &config); err != nil {
 		return fmt.Errorf("unmarshal yaml to struct: %v", err)
 	}
+	for i := range config.Deployments {
+		config.Deployments[i].Source = configPath
+	}
+	if err := config.include(configPath); err != nil {
+		return err
+	}
+	config.setDefaults()
+	config.problems = append(config.resolveSecrets(), config.checkRoutes()...)
 	return nil
 }
+
+// checkRoutes checks that the names and the webhook URLs of the
+// deployments are unique, across the included files too.
+func (config *Config) checkRoutes() []error {
+	var errs []error
+	names, hooks := map[string]string{}, map[string]string{}
+	for _, d := range config.Deployments {
+		if d.Name != "" {
+			if source, ok := names[d.Name]; ok {
+				errs = append(errs, fmt.Errorf("duplicate deployment name %q in %v and %v",
+					d.Name, source, d.Source))
+				continue
+			}
+			names[d.Name] = d.Source
+		}
+		if path := d.HookPath(); path != "" {
+			if source, ok := hooks[path]; ok {
+				errs = append(errs, fmt.Errorf("duplicate hook path %q in %v and %v",
+					path, source, d.Source))
+			}
+			hooks[path] = d.Source
+		}
+	}
+	return errs
+}
+
+// setDefaults sets the server options that are not configured.
+func (config *Config) setDefaults() {
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+	defaults := []struct {
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{&config.ReadTimeout, DefaultReadTimeout},
+		{&config.ReadHeaderTimeout, DefaultReadHeaderTimeout},
+		{&config.WriteTimeout, DefaultWriteTimeout},
+		{&config.IdleTimeout, DefaultIdleTimeout},
+	}
+	for _, d := range defaults {
+		if *d.value <= 0 {
+			*d.value = d.fallback
+		}
+	}
+}
@@ -3,9 +3,16 @@ package config_test
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	config "github.com/klipitkas/hooktail/config"
+	"github.com/klipitkas/hooktail/deployment"
 )
 
 func TestParse(t *testing.T) {
@@ -59,3 +66,404 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseServerDefaults(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		want    config.Config
+	}{
+		{
+			"Parse a configuration without server options",
+			`port: 5042`,
+			config.Config{
+				Port:              5042,
+				MaxBodySize:       config.DefaultMaxBodySize,
+				ReadTimeout:       config.DefaultReadTimeout,
+				ReadHeaderTimeout: config.DefaultReadHeaderTimeout,
+				WriteTimeout:      config.DefaultWriteTimeout,
+				IdleTimeout:       config.DefaultIdleTimeout,
+			},
+		},
+		{
+			"Parse a configuration with server options",
+			"port: 5042\nmax_body_size: 1024\nread_timeout: 5s\nread_header_timeout: 2s\nwrite_timeout: 1m\nidle_timeout: 0s",
+			config.Config{
+				Port:              5042,
+				MaxBodySize:       1024,
+				ReadTimeout:       5 * time.Second,
+				ReadHeaderTimeout: 2 * time.Second,
+				WriteTimeout:      time.Minute,
+				IdleTimeout:       config.DefaultIdleTimeout,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(os.TempDir(), "hooktail-defaults.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Errorf("writefile failed %v", err)
+				return
+			}
+			defer os.Remove(path)
+			var got config.Config
+			if err := config.Parse(&got, path); err != nil {
+				t.Errorf("error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v, want = %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSecrets(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hooktail")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	private := filepath.Join(dir, "private")
+	if err := ioutil.WriteFile(private, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	public := filepath.Join(dir, "public")
+	if err := ioutil.WriteFile(public, []byte("from-file\n"), 0644); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+	os.Setenv("HOOKTAIL_TEST_SECRET", "from-env")
+	defer os.Unsetenv("HOOKTAIL_TEST_SECRET")
+	os.Setenv("HOOKTAIL_TEST_EMPTY", "")
+	defer os.Unsetenv("HOOKTAIL_TEST_EMPTY")
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			"Parse a secret from an environment variable",
+			"deployments:\n  - secret_env: HOOKTAIL_TEST_SECRET",
+			"from-env",
+			false,
+		},
+		{
+			"Parse a secret with an interpolated environment variable",
+			"deployments:\n  - secret: prefix-${HOOKTAIL_TEST_SECRET}",
+			"prefix-from-env",
+			false,
+		},
+		{
+			"Parse a secret from a file",
+			"deployments:\n  - secret_file: " + private,
+			"from-file",
+			false,
+		},
+		{
+			"Parse a secret from a missing environment variable should fail",
+			"deployments:\n  - secret_env: HOOKTAIL_TEST_MISSING",
+			"",
+			true,
+		},
+		{
+			"Parse a secret with a missing interpolated variable should fail",
+			"deployments:\n  - secret: ${HOOKTAIL_TEST_MISSING}",
+			"",
+			true,
+		},
+		{
+			"Parse a secret with an empty interpolated variable should fail",
+			"deployments:\n  - secret: ${HOOKTAIL_TEST_EMPTY}",
+			"",
+			true,
+		},
+		{
+			"Parse a secret from a file with loose permissions should fail",
+			"deployments:\n  - secret_file: " + public,
+			"",
+			true,
+		},
+		{
+			"Parse a secret with both a value and a file should fail",
+			"deployments:\n  - secret: inline\n    secret_file: " + private,
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Errorf("writefile failed %v", err)
+				return
+			}
+			var got config.Config
+			err := config.Parse(&got, path)
+			if err == nil {
+				err = problem(got, "secret")
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Deployments[0].Secret != tt.want {
+				t.Errorf("got = %q, want = %q", got.Deployments[0].Secret, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hooktail")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0755); err != nil {
+		t.Fatalf("create .git directory: %v", err)
+	}
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("current user: %v", err)
+	}
+	valid := deployment.Deployment{
+		User:       current.Username,
+		Repository: "git@github.com:klipitkas/hooktail.git",
+		Branch:     "master",
+		Path:       dir,
+	}
+	missing := valid
+	missing.BeforeScript = filepath.Join(dir, "missing.sh")
+	missing.SkipPattern = "("
+	pushers := valid
+	pushers.AllowedPushers = []string{"klipitkas"}
+	pushers.Events = []string{"push", "tag_push"}
+	runs := pushers
+	runs.Events = []string{"push", "workflow_run"}
+	senders := valid
+	senders.AllowedSenders = []string{"klipitkas"}
+	senders.Events = []string{"pull_request"}
+
+	tests := []struct {
+		name        string
+		deployments []deployment.Deployment
+		wantErrs    int
+	}{
+		{"Check a valid deployment", []deployment.Deployment{valid}, 0},
+		{"Check every problem of a deployment is found", []deployment.Deployment{missing}, 2},
+		{"Check duplicate deployments", []deployment.Deployment{valid, valid}, 1},
+		{"Check allowed pushers of push events", []deployment.Deployment{pushers}, 0},
+		{"Check allowed pushers of workflow runs", []deployment.Deployment{runs}, 1},
+		{"Check allowed senders of pull requests", []deployment.Deployment{senders}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath("git"); err != nil {
+				t.Skip("git is not installed")
+			}
+			c := config.Config{Deployments: tt.deployments}
+			if errs := c.Check(); len(errs) != tt.wantErrs {
+				t.Errorf("errors = %v, want %d errors", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hooktail")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(path, []byte("port: 5042"), 0644); err != nil {
+		t.Fatalf("writefile failed %v", err)
+	}
+
+	changed := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	files := func() []string { return []string{path} }
+	go config.Watch(files, 10*time.Millisecond, stop, func() { changed <- struct{}{} })
+
+	select {
+	case <-changed:
+		t.Fatalf("unchanged file is reported as changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := ioutil.WriteFile(path, []byte("port: 5043"), 0644); err != nil {
+		t.Fatalf("writefile failed %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("change file times: %v", err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Errorf("changed file is not reported")
+	}
+}
+
+func TestParseInclude(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		config      string
+		fragments   map[string]string
+		wantSources []string
+		wantErr     string
+	}{
+		{
+			"Parse the deployments of the included files",
+			"include:\n  - conf.d/*.yml\ndeployments:\n  - name: main",
+			map[string]string{
+				"api.yml": "deployments:\n  - name: api",
+				"web.yml": "deployments:\n  - name: web\n  - name: docs",
+			},
+			[]string{"config.yml", "conf.d/api.yml", "conf.d/web.yml", "conf.d/web.yml"},
+			"",
+		},
+		{
+			"Parse duplicate names across included files should fail",
+			"include:\n  - conf.d/*.yml",
+			map[string]string{
+				"api.yml": "deployments:\n  - name: api",
+				"web.yml": "deployments:\n  - name: api",
+			},
+			nil,
+			"conf.d/web.yml",
+		},
+		{
+			"Parse an invalid included file should fail",
+			"include:\n  - conf.d/*.yml",
+			map[string]string{"api.yml": "deployments: -api-"},
+			nil,
+			"conf.d/api.yml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "hooktail")
+			if err != nil {
+				t.Fatalf("create temporary directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			if err := os.Mkdir(filepath.Join(dir, "conf.d"), 0755); err != nil {
+				t.Fatalf("create include directory: %v", err)
+			}
+			path := filepath.Join(dir, "config.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatalf("writefile failed %v", err)
+			}
+			for name, content := range tt.fragments {
+				file := filepath.Join(dir, "conf.d", name)
+				if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+					t.Fatalf("writefile failed %v", err)
+				}
+			}
+
+			var got config.Config
+			err = config.Parse(&got, path)
+			if err == nil {
+				err = problem(got, "duplicate deployment name")
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want an error about %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("error = %v", err)
+				return
+			}
+			var sources []string
+			for _, d := range got.Deployments {
+				rel, _ := filepath.Rel(dir, d.Source)
+				sources = append(sources, rel)
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("got = %v, want = %v", sources, tt.wantSources)
+			}
+			if files := got.Files(path); len(files) != len(tt.fragments)+1 {
+				t.Errorf("got files %v, want the configuration and %d fragments",
+					files, len(tt.fragments))
+			}
+		})
+	}
+}
+
+func TestCheckParseProblems(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			"Check duplicate deployment names",
+			"deployments:\n  - name: api\n  - name: api",
+			[]string{`duplicate deployment name "api"`},
+		},
+		{
+			"Check duplicate hook paths",
+			"deployments:\n  - name: api\n  - name: web\n    hook: /hooks/api",
+			[]string{`duplicate hook path "/hooks/api"`},
+		},
+		{
+			"Check every parse problem is reported together",
+			"deployments:\n  - name: api\n    secret_env: HOOKTAIL_TEST_MISSING\n" +
+				"  - name: api\n    secret: ${HOOKTAIL_TEST_MISSING}",
+			[]string{
+				"deployment api in",
+				"environment variable HOOKTAIL_TEST_MISSING is not set",
+				`duplicate deployment name "api"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(os.TempDir(), "hooktail-problems.yml")
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Errorf("writefile failed %v", err)
+				return
+			}
+			defer os.Remove(path)
+			var got config.Config
+			if err := config.Parse(&got, path); err != nil {
+				t.Errorf("error = %v", err)
+				return
+			}
+			for _, want := range tt.want {
+				if problem(got, want) == nil {
+					t.Errorf("problems = %v, want a problem about %v", got.Check(), want)
+				}
+			}
+		})
+	}
+}
+
+// problem returns the first problem that Check reports about a topic.
+func problem(c config.Config, topic string) error {
+	for _, err := range c.Check() {
+		if strings.Contains(err.Error(), topic) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/klipitkas/hooktail/deployment"
+	"gopkg.in/yaml.v2"
+)
+
+// fragment is a file included by the configuration.
+type fragment struct {
+	// The deployments of the file.
+	Deployments []deployment.Deployment `yaml:"deployments,omitempty"`
+}
+
+// includedFiles returns the files that match the include globs, which
+// are relative to the directory of the configuration file.
+func (config *Config) includedFiles(configPath string) ([]string, error) {
+	var files []string
+	for _, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(configPath), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include %q: %v", pattern, err)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// Files returns the configuration file and the files it includes.
+func (config *Config) Files(configPath string) []string {
+	files, _ := config.includedFiles(configPath)
+	return append([]string{configPath}, files...)
+}
+
+// include appends the deployments of the included files.
+func (config *Config) include(configPath string) error {
+	files, err := config.includedFiles(configPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read included yaml file %v: %v", file, err)
+		}
+		var f fragment
+		if err := yaml.Unmarshal(b, &f); err != nil {
+			return fmt.Errorf("unmarshal included yaml file %v: %v", file, err)
+		}
+		for _, d := range f.Deployments {
+			d.Source = file
+			config.Deployments = append(config.Deployments, d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envPattern matches the "${NAME}" references to environment variables.
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveSecrets replaces the secrets and tokens of the deployments with
+// the values of the environment variables and files they refer to, and
+// returns every reference that cannot be resolved. Unresolved secrets
+// are left empty, which no request can be verified with.
+func (config *Config) resolveSecrets() []error {
+	var errs []error
+	for i := range config.Deployments {
+		d := &config.Deployments[i]
+		name := label(i, *d)
+		var err error
+		if d.Secret, err = resolveSecret(d.Secret, d.SecretEnv, d.SecretFile); err != nil {
+			errs = append(errs, fmt.Errorf("deployment %v: secret: %v", name, err))
+		}
+		for j := range d.Secrets {
+			s := &d.Secrets[j]
+			if s.Value, err = resolveSecret(s.Value, s.Env, s.File); err != nil {
+				errs = append(errs, fmt.Errorf("deployment %v: secrets[%d]: %v", name, j, err))
+			}
+		}
+		if g := d.Generic; g != nil {
+			if g.Token, err = resolveSecret(g.Token, g.TokenEnv, g.TokenFile); err != nil {
+				errs = append(errs, fmt.Errorf("deployment %v: generic token: %v", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+// resolveSecret returns the secret of an inline value with "${NAME}"
+// references, an environment variable or a file, of which at most one
+// can be set.
+func resolveSecret(value string, env string, file string) (string, error) {
+	set := 0
+	for _, s := range []string{value, env, file} {
+		if s != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", errors.New("only one of a value, an environment variable " +
+			"or a file can be used")
+	}
+
+	switch {
+	case env != "":
+		v, ok := os.LookupEnv(env)
+		if !ok || v == "" {
+			return "", fmt.Errorf("environment variable %v is not set", env)
+		}
+		return v, nil
+	case file != "":
+		return readSecretFile(file)
+	}
+	resolved, err := interpolate(value)
+	if err != nil {
+		return "", err
+	}
+	// An empty secret would silently turn off the signature checks.
+	if value != "" && resolved == "" {
+		return "", fmt.Errorf("%v resolves to an empty value", value)
+	}
+	return resolved, nil
+}
+
+// interpolate replaces the "${NAME}" references of a value with the
+// environment variables they refer to.
+func interpolate(value string) (string, error) {
+	var missing []string
+	resolved := envPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envPattern.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %v is not set",
+			strings.Join(missing, ", "))
+	}
+	return resolved, nil
+}
+
+// readSecretFile reads a secret from a file that only its owner can
+// access, without the trailing newline.
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("stat secret file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return "", fmt.Errorf("secret file %v is accessible by other users "+
+			"(mode %#o), it should be 0600 or 0400", path, perm)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret file: %v", err)
+	}
+	secret := strings.TrimRight(string(b), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("secret file %v is empty", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch calls changed whenever the modification time or the size of
+// any of the files changes, checking them at the interval until stop
+// is closed. The files are listed again after every change, e.g. to
+// follow the files that the configuration includes.
+func Watch(files func() []string, interval time.Duration, stop <-chan struct{}, changed func()) {
+	last := snapshot(files())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if next := snapshot(files()); !sameSnapshot(last, next) {
+			changed()
+			last = snapshot(files())
+		}
+	}
+}
+
+// fileState is the state of a file that is compared to detect changes.
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// snapshot returns the state of every file.
+func snapshot(files []string) map[string]fileState {
+	states := map[string]fileState{}
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			states[f] = fileState{}
+			continue
+		}
+		states[f] = fileState{info.ModTime(), info.Size(), true}
+	}
+	return states
+}
+
+// sameSnapshot reports whether none of the files changed.
+func sameSnapshot(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for f, s := range a {
+		if other, ok := b[f]; !ok || !other.modTime.Equal(s.modTime) ||
+			other.size != s.size || other.exists != s.exists {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/network"
+	"github.com/klipitkas/hooktail/request"
+	log "github.com/sirupsen/logrus"
+)
+
+// Authorize checks the pusher and the sender of an event against the
+// allowed and denied lists of a deployment.
+func Authorize(d Deployment, event request.Event) error {
+	// Display names are chosen freely by users, so only the login and
+	// the email identify a pusher.
+	pusher := identities(event.Pusher.Username, event.Pusher.Email)
+	sender := identities(event.Sender.Username)
+
+	if matchesUser(d.DeniedPushers, pusher) {
+		return fmt.Errorf("pusher %v is denied", describe(event.Pusher))
+	}
+	if matchesUser(d.DeniedSenders, sender) {
+		return fmt.Errorf("sender %v is denied", describe(event.Sender))
+	}
+	if len(d.AllowedPushers) > 0 && !matchesUser(d.AllowedPushers, pusher) {
+		return fmt.Errorf("pusher %v is not allowed", describe(event.Pusher))
+	}
+	if len(d.AllowedSenders) > 0 && !matchesUser(d.AllowedSenders, sender) {
+		return fmt.Errorf("sender %v is not allowed", describe(event.Sender))
+	}
+	return nil
+}
+
+// pushEvents are the event types that have a pusher.
+var pushEvents = []string{request.EventPush, "tag_push"}
+
+// validatePushers checks that the pusher lists of a deployment can be
+// applied to its events, since any other event has no pusher and would
+// never be allowed.
+func validatePushers(d Deployment) error {
+	if len(d.AllowedPushers) == 0 {
+		return nil
+	}
+	if d.Generic != nil {
+		return errors.New("allowed pushers cannot be used with a generic webhook, use allowed senders")
+	}
+	for _, e := range d.Events {
+		if !contains(pushEvents, e) {
+			return fmt.Errorf("allowed pushers cannot be used with %v events, use allowed senders", e)
+		}
+	}
+	return nil
+}
+
+// AllowsAddress checks the address of the client against the allowed
+// networks of a deployment.
+func AllowsAddress(d Deployment, ip net.IP) error {
+	if len(d.AllowedNetworks) == 0 {
+		return nil
+	}
+	networks, err := network.ParseNetworks(d.AllowedNetworks)
+	if err != nil {
+		return fmt.Errorf("parse allowed networks: %v", err)
+	}
+	if !network.Contains(networks, ip) {
+		return fmt.Errorf("address %v is not allowed", ip)
+	}
+	return nil
+}
+
+// LogRejection records that an event was not authorized to trigger a
+// deployment.
+func LogRejection(d Deployment, event request.Event, err error) {
+	logging.Log.Warnf("Rejecting deployment of %v: %v", d.Repository, err)
+	logging.Audit("request rejected", log.Fields{
+		"repository": d.Repository,
+		"deployment": d.Name,
+		"ref":        event.Ref,
+		"pusher":     describe(event.Pusher),
+		"sender":     describe(event.Sender),
+		"reason":     err.Error(),
+	})
+}
+
+// identities returns the non empty identities of a user in lower case.
+func identities(values ...string) []string {
+	ids := []string{}
+	for _, v := range values {
+		if v != "" {
+			ids = append(ids, strings.ToLower(v))
+		}
+	}
+	return ids
+}
+
+// matchesUser reports whether any of the patterns matches any of the
+// identities of a user.
+func matchesUser(patterns, ids []string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		for _, id := range ids {
+			if ok, _ := path.Match(p, id); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe returns a readable description of a user.
+func describe(u request.User) string {
+	switch {
+	case u.Username != "":
+		return u.Username
+	case u.Email != "":
+		return u.Email
+	case u.Name != "":
+		return u.Name
+	}
+	return "(unknown)"
+}
@@ -0,0 +1,52 @@
+package deployment
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/klipitkas/hooktail/common"
+	"github.com/klipitkas/hooktail/logging"
+)
+
+// Clean contains the options of the optional "git clean" step that
+// removes untracked files after the hard reset.
+type Clean struct {
+	// Run "git clean -fdx" after the deployment has been reset.
+	Enabled bool `yaml:"enabled,omitempty" json:"enabled,omitempty"`
+	// Only log the files that would be removed.
+	DryRun bool `yaml:"dry_run,omitempty" json:"dry_run,omitempty"`
+	// The paths that are kept, e.g. ".env" or "storage/".
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+}
+
+// args returns the arguments for the git clean command.
+func (c Clean) args() []string {
+	args := []string{"clean", "-fdx"}
+	if c.DryRun {
+		args = []string{"clean", "-ndx"}
+	}
+	for _, e := range c.Exclude {
+		args = append(args, "-e", e)
+	}
+	return args
+}
+
+// clean removes untracked and ignored files from the deployment path,
+// keeping any excluded path.
+func clean(d Deployment) error {
+	if !d.Clean.Enabled {
+		return nil
+	}
+	out, err := common.ExecuteCommand("git", d.User, d.Path, d.Clean.args()...)
+	if err != nil {
+		return fmt.Errorf("git clean: %v", err)
+	}
+	if d.Clean.DryRun {
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			if line != "" {
+				logging.Log.Printf("Clean dry run for %v: %v", d.Path, line)
+			}
+		}
+	}
+	return nil
+}
@@ -6,88 +6,256 @@ import (
 	"os"
 	"os/user"
 	"path"
+	"strconv"
+	"syscall"
 
 	"github.com/klipitkas/hooktail/common"
 	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/network"
 	"github.com/klipitkas/hooktail/request"
 )
 
 // Deployment is the specific deployment configuration.
 type Deployment struct {
+	// The name of the deployment, e.g. used by "[deploy:<name>]" and
+	// its "/hooks/<name>" webhook URL.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// The path of the webhook URL of the deployment instead of
+	// "/hooks/<name>", e.g. with an unguessable token. Deployments with
+	// a custom hook are not served on the root path.
+	Hook string `yaml:"hook,omitempty" json:"hook,omitempty"`
 	// The secret for checking the integrity of the request.
 	Secret string `yaml:"secret,omitempty" json:"secret,omitempty"`
+	// The environment variable that holds the secret.
+	SecretEnv string `yaml:"secret_env,omitempty" json:"secret_env,omitempty"`
+	// The file that holds the secret, which must not be accessible by
+	// other users.
+	SecretFile string `yaml:"secret_file,omitempty" json:"secret_file,omitempty"`
+	// The secrets of which any validates a request, e.g. the old and the
+	// new secret while rotating them.
+	Secrets []Secret `yaml:"secrets,omitempty" json:"secrets,omitempty"`
 	// The username of the user that will perform the deployment.
 	User string `yaml:"user,omitempty" json:"user,omitempty"`
 	// The repository of the project that will be deployed.
 	Repository string `yaml:"repository,omitempty" json:"repository,omitempty"`
 	// The branch that will be deployed.
 	Branch string `yaml:"branch,omitempty" json:"branch,omitempty"`
+	// The tag that will be deployed instead of a branch.
+	Tag string `yaml:"tag,omitempty" json:"tag,omitempty"`
+	// The options that limit what is fetched from the remote.
+	Fetch Fetch `yaml:"fetch,omitempty" json:"fetch,omitempty"`
 	// The path where the deployment will take place.
 	Path string `yaml:"path,omitempty" json:"path,omitempty"`
+	// The options for removing untracked files after the deployment.
+	Clean Clean `yaml:"clean,omitempty" json:"clean,omitempty"`
 	// Any script that should be ran before the deployment.
 	BeforeScript string `yaml:"before_script,omitempty" json:"before_script,omitempty"`
 	// Any script that should be ran after the deployment.
 	AfterScript string `yaml:"after_script,omitempty" json:"after_script,omitempty"`
+	// The working directory of the scripts, defaults to the path.
+	WorkDir string `yaml:"work_dir,omitempty" json:"work_dir,omitempty"`
+	// The interpreter of the scripts, by default executable scripts are
+	// ran directly and any other script with /bin/sh.
+	Interpreter string `yaml:"interpreter,omitempty" json:"interpreter,omitempty"`
+	// The event types that trigger the deployment, defaults to pushes.
+	Events []string `yaml:"events,omitempty" json:"events,omitempty"`
+	// The names of the workflows whose successful runs trigger the
+	// deployment on workflow_run and check_suite events.
+	Workflows []string `yaml:"workflows,omitempty" json:"workflows,omitempty"`
+	// The labels of which a merged pull request needs any to trigger
+	// the deployment on pull_request events.
+	Labels []string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// The globs of the changed files that trigger the deployment.
+	Paths []string `yaml:"paths,omitempty" json:"paths,omitempty"`
+	// The globs of the changed files that never trigger the deployment.
+	PathsIgnore []string `yaml:"paths_ignore,omitempty" json:"paths_ignore,omitempty"`
+	// The regular expression of the commit message directives that skip
+	// the deployment, defaults to DefaultSkipPattern.
+	SkipPattern string `yaml:"skip_pattern,omitempty" json:"skip_pattern,omitempty"`
+	// The pushers that may trigger the deployment, matched against the
+	// username and email, e.g. "*@example.com". Display names are not
+	// matched, since users can change them freely. Only push events have
+	// a pusher, so the list cannot be used with any other event.
+	AllowedPushers []string `yaml:"allowed_pushers,omitempty" json:"allowed_pushers,omitempty"`
+	// The senders that may trigger the deployment, matched against the
+	// login of the user that triggered the event.
+	AllowedSenders []string `yaml:"allowed_senders,omitempty" json:"allowed_senders,omitempty"`
+	// The pushers that never trigger the deployment.
+	DeniedPushers []string `yaml:"denied_pushers,omitempty" json:"denied_pushers,omitempty"`
+	// The senders that never trigger the deployment.
+	DeniedSenders []string `yaml:"denied_senders,omitempty" json:"denied_senders,omitempty"`
+	// The networks that may trigger the deployment, which can only narrow
+	// the global allowed networks.
+	AllowedNetworks []string `yaml:"allowed_networks,omitempty" json:"allowed_networks,omitempty"`
+	// The generic webhook configuration, for deployments that are
+	// triggered by CI systems or custom tools instead of a forge.
+	Generic *request.Generic `yaml:"generic,omitempty" json:"generic,omitempty"`
+	// The deployment pipeline, replaces the before and after scripts.
+	Steps []Step `yaml:"steps,omitempty" json:"steps,omitempty"`
+
+	// The configuration file the deployment is defined in.
+	Source string `yaml:"-" json:"source,omitempty"`
+
+	// The commit that is deployed instead of the head of the branch.
+	commit string
+	// The environment of the scripts, describing the event.
+	env []string
+	// Whether the repository is already fetched for this deployment.
+	fetched bool
 }
 
-// Validate validates a specified deployment configuration.
+// Validate validates a specified deployment configuration and returns
+// the first problem that Check finds.
 func Validate(d Deployment) error {
+	if errs := Check(d); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Check returns every problem of a deployment configuration.
+func Check(d Deployment) []error {
+	var errs []error
 
 	// Basic validation checks
 	if d.User == "" {
-		return errors.New("invalid or empty user")
+		errs = append(errs, errors.New("invalid or empty user"))
 	}
 	if d.Repository == "" {
-		return errors.New("invalid repository")
+		errs = append(errs, errors.New("invalid repository"))
+	}
+	if d.Branch == "" && d.Tag == "" {
+		errs = append(errs, errors.New("invalid branch"))
 	}
-	if d.Branch == "" {
-		return errors.New("invalid branch")
+	if d.Branch != "" && d.Tag != "" {
+		errs = append(errs, errors.New("branch and tag cannot be used together"))
+	}
+	if d.Fetch.Depth < 0 {
+		errs = append(errs, fmt.Errorf("invalid fetch depth %d", d.Fetch.Depth))
 	}
 	if d.Path == "" {
-		return errors.New("invalid deployment path")
+		errs = append(errs, errors.New("invalid deployment path"))
+	}
+	if err := validateRoute(d); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateSecrets(d); err != nil {
+		errs = append(errs, err)
+	}
+
+	for _, p := range append(append([]string{}, d.Paths...), d.PathsIgnore...) {
+		if _, err := globRegexp(p); err != nil {
+			errs = append(errs, fmt.Errorf("invalid path glob %q: %v", p, err))
+		}
 	}
 
-	// System validation checks
-	// if _, err := os.Stat("git"); os.IsNotExist(err) {
-	// 	return fmt.Errorf("check git command existence: %v", err)
-	// }
+	users := append(append([]string{}, d.AllowedPushers...), d.AllowedSenders...)
+	for _, p := range append(append(users, d.DeniedPushers...), d.DeniedSenders...) {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid user pattern %q: %v", p, err))
+		}
+	}
 
-	if _, err := user.Lookup(d.User); err != nil {
-		return fmt.Errorf("check user existence %s: %v", d.User, err)
+	if err := validatePushers(d); err != nil {
+		errs = append(errs, err)
 	}
 
-	if _, err := os.Stat(d.Path); os.IsNotExist(err) {
-		return fmt.Errorf("check path existence %s: %v", d.Path, err)
+	if _, err := network.ParseNetworks(d.AllowedNetworks); err != nil {
+		errs = append(errs, fmt.Errorf("invalid allowed networks: %v", err))
 	}
 
-	gitDir := path.Join(d.Path, ".git")
-	if _, err := os.Stat(gitDir); os.IsNotExist(err) {
-		return fmt.Errorf("check .git inside path %s existence: %v", gitDir, err)
+	if _, err := d.skipPattern(); err != nil {
+		errs = append(errs, fmt.Errorf("invalid skip pattern: %v", err))
 	}
 
-	// Checking before and after script existence
-	if d.BeforeScript != "" {
-		if _, err := os.Stat(d.BeforeScript); os.IsNotExist(err) {
-			return fmt.Errorf("check before script %s existence: %v",
-				d.BeforeScript, err)
+	if d.Generic != nil {
+		if err := d.Generic.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("check generic webhook: %v", err))
+		}
+		if !d.HasSecret() && d.Generic.Token == "" {
+			errs = append(errs, errors.New("a generic webhook requires a secret or a token"))
 		}
 	}
 
-	if d.AfterScript != "" {
-		if _, err := os.Stat(d.AfterScript); os.IsNotExist(err) {
-			return fmt.Errorf("check after script %s existence: %v",
-				d.AfterScript, err)
+	// System validation checks, skipping those of missing options,
+	// the git command is checked once by the configuration.
+	var u *user.User
+	if d.User != "" {
+		var err error
+		if u, err = user.Lookup(d.User); err != nil {
+			errs = append(errs, fmt.Errorf("check user existence %s: %v", d.User, err))
 		}
 	}
 
+	if d.Path != "" {
+		gitDir := path.Join(d.Path, ".git")
+		if _, err := os.Stat(d.Path); os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("check path existence %s: %v", d.Path, err))
+		} else if info, err := os.Stat(gitDir); os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("check .git inside path %s existence: %v", gitDir, err))
+		} else if err == nil && u != nil {
+			// Git refuses to work in repositories owned by another user.
+			if err := checkOwner(info, u); err != nil {
+				errs = append(errs, fmt.Errorf("check .git inside path %s ownership: %v", gitDir, err))
+			}
+		}
+	}
+
+	if d.WorkDir != "" {
+		if _, err := os.Stat(d.WorkDir); os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("check work dir %s existence: %v", d.WorkDir, err))
+		}
+	}
+
+	// Checking before and after script existence, inline scripts
+	// do not need to exist.
+	if err := validateScript(d.BeforeScript); err != nil {
+		errs = append(errs, fmt.Errorf("check before script %s existence: %v",
+			d.BeforeScript, err))
+	}
+
+	if err := validateScript(d.AfterScript); err != nil {
+		errs = append(errs, fmt.Errorf("check after script %s existence: %v",
+			d.AfterScript, err))
+	}
+
+	// Checking the pipeline steps
+	if len(d.Steps) > 0 && (d.BeforeScript != "" || d.AfterScript != "") {
+		errs = append(errs, errors.New("before and after scripts cannot be used with steps"))
+	}
+
+	for i, s := range d.Steps {
+		if err := validateStep(s); err != nil {
+			errs = append(errs, fmt.Errorf("check step %d (%v): %v", i+1, s, err))
+		}
+	}
+
+	return errs
+}
+
+// checkOwner checks that a file is owned by a user.
+func checkOwner(info os.FileInfo, u *user.User) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if uid := strconv.FormatUint(uint64(stat.Uid), 10); uid != u.Uid {
+		return fmt.Errorf("owned by uid %v instead of %v (%v)", uid, u.Username, u.Uid)
+	}
 	return nil
 }
 
-// Deploy executes a specific deployment configuration.
-func Deploy(d Deployment) error {
+// Deploy executes a specific deployment configuration for the event
+// that triggered it.
+func Deploy(d Deployment, event request.Event) error {
 
 	logging.Log.Printf("Starting deployment for repository: %v", d.Repository)
 
+	// Deploy the commit of the event when it requires one and
+	// expose the event to the scripts.
+	d.commit = pinnedCommit(event)
+	d.env = eventEnv(event)
+
 	// Validate the deployment first.
 	if err := Validate(d); err != nil {
 		return fmt.Errorf("validate deployment: %v", err)
@@ -95,6 +263,28 @@ func Deploy(d Deployment) error {
 
 	logging.Log.Printf("Validated deployment information.")
 
+	// Check the path filters against the changed files, which needs
+	// a fetch when the payload does not list all of them.
+	if d.hasPathFilters() && !Forced(d, event) {
+		files, known, err := changedFiles(&d, event)
+		if err != nil {
+			return fmt.Errorf("changed files: %v", err)
+		}
+		if reason := pathSkipReason(d, files); known && reason != "" {
+			LogSkip(d, event, reason)
+			return nil
+		}
+	}
+
+	// Run the pipeline when steps are configured.
+	if len(d.Steps) > 0 {
+		if err := runSteps(d); err != nil {
+			return fmt.Errorf("run steps: %v", err)
+		}
+		logging.Log.Printf("Deployment for repository: %v has been completed.", d.Repository)
+		return nil
+	}
+
 	// Execute any script that needs to be executed before
 	// the deployment.
 	if err := runBefore(d); err != nil {
@@ -124,29 +314,58 @@ func Deploy(d Deployment) error {
 
 // run executes the core deployment commands.
 func run(d Deployment) error {
-	// Run the deployment
-	args := []string{"remote", "update"}
-	if _, err := common.ExecuteCommand("git", d.User, d.Path, args...); err != nil {
-		return fmt.Errorf("git remote update: %v", err)
+	// Run the deployment, the path filters may have fetched already.
+	if !d.fetched {
+		if err := fetch(d); err != nil {
+			return err
+		}
 	}
 
-	args = []string{"checkout", d.Branch}
-	if _, err := common.ExecuteCommand("git", d.User, d.Path, args...); err != nil {
-		return fmt.Errorf("checkout to branch %v: %v", d.Branch, err)
+	if len(d.Fetch.SparsePaths) > 0 {
+		args := append([]string{"sparse-checkout", "set"}, d.Fetch.SparsePaths...)
+		if _, err := common.ExecuteCommand("git", d.User, d.Path, args...); err != nil {
+			return fmt.Errorf("git sparse-checkout set: %v", err)
+		}
 	}
 
-	args = []string{"reset", "--hard", "origin/" + d.Branch}
+	if d.Tag != "" {
+		args := []string{"checkout", "--detach", d.revision()}
+		if _, err := common.ExecuteCommand("git", d.User, d.Path, args...); err != nil {
+			return fmt.Errorf("checkout to tag %v: %v", d.Tag, err)
+		}
+	} else {
+		args := []string{"checkout", d.Branch}
+		if _, err := common.ExecuteCommand("git", d.User, d.Path, args...); err != nil {
+			return fmt.Errorf("checkout to branch %v: %v", d.Branch, err)
+		}
+	}
+
+	args := []string{"reset", "--hard", d.revision()}
 	if _, err := common.ExecuteCommand("git", d.User, d.Path, args...); err != nil {
-		return fmt.Errorf("hard reset to %v branch: %v", d.Branch, err)
+		return fmt.Errorf("hard reset to %v: %v", d.revision(), err)
+	}
+
+	if err := clean(d); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// runScript runs a bash deployment script.
-func runScript(path string, user string) error {
-	args := []string{path}
-	if _, err := common.ExecuteCommand("/bin/sh", user, "", args...); err != nil {
+// runScript runs a deployment script, either from a file or from an
+// inline body, inside the working directory of the deployment.
+func runScript(d Deployment, script string) error {
+	if isInline(script) {
+		tmp, err := writeScript(script, d.User)
+		if err != nil {
+			return fmt.Errorf("inline script: %v", err)
+		}
+		defer os.Remove(tmp)
+		script = tmp
+	}
+	name, args := scriptCommand(script, d.Interpreter)
+	cmd := common.Command{Name: name, Args: args, User: d.User, Dir: d.workDir(), Env: d.env}
+	if _, err := cmd.Execute(); err != nil {
 		return fmt.Errorf("run script: %v", err)
 	}
 	return nil
@@ -160,7 +379,7 @@ func runBefore(d Deployment) error {
 		return nil
 	}
 	// Run the before script
-	if err := runScript(d.BeforeScript, d.User); err != nil {
+	if err := runScript(d, d.BeforeScript); err != nil {
 		return fmt.Errorf("before script: %v", err)
 	}
 	return nil
@@ -174,19 +393,54 @@ func runAfter(d Deployment) error {
 		return nil
 	}
 	// Run the after script
-	if err := runScript(d.AfterScript, d.User); err != nil {
+	if err := runScript(d, d.AfterScript); err != nil {
 		return fmt.Errorf("after script: %v", err)
 	}
 	return nil
 }
 
-// FindMatching searches for a matching deployment in the YAML
-// configuration file when parsing the request.
-func FindMatching(list []Deployment, req request.Request) Deployment {
+// FindMatching searches for the matching deployments in the YAML
+// configuration file when parsing the request, e.g. all the services
+// of a monorepo. Deployments with a generic webhook only match when
+// their expressions and conditions accept the body of the request,
+// any other deployment only matches the event types it is triggered by.
+func FindMatching(list []Deployment, req request.Request) []Deployment {
+	var matches []Deployment
 	for _, dep := range list {
-		if dep.Repository == req.Body.Repository.SSHURL {
-			return dep
+		if dep.Generic != nil {
+			if matchesGeneric(dep, req) {
+				matches = append(matches, dep)
+			}
+			continue
 		}
+		if req.Event.Repository.Matches(dep.Repository) &&
+			matchesEvent(dep, req.Event) {
+			matches = append(matches, dep)
+		}
+	}
+	return matches
+}
+
+// matchesGeneric reports whether the generic webhook of a deployment
+// accepts the request. When the webhook extracts a reference, it must
+// be the branch or the tag of the deployment.
+func matchesGeneric(d Deployment, req request.Request) bool {
+	if d.Generic.Validate() != nil {
+		return false
+	}
+	if err := req.ParseWith(*d.Generic, []byte(req.JSONBody)); err != nil {
+		return false
+	}
+	if d.Generic.Repository != "" && !req.Event.Repository.Matches(d.Repository) {
+		return false
+	}
+	if d.Generic.Ref != "" && !matchesRef(d, req.Event) {
+		return false
+	}
+	// The extracted commit is deployed, which must not fall back to the
+	// head of the branch.
+	if d.Generic.SHA != "" && req.Event.After == "" {
+		return false
 	}
-	return Deployment{}
+	return matchesEvent(d, req.Event)
 }
@@ -1,9 +1,18 @@
 package deployment_test
 
 import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/klipitkas/hooktail/common"
 	"github.com/klipitkas/hooktail/deployment"
+	"github.com/klipitkas/hooktail/request"
 )
 
 func TestValidateDeployment(t *testing.T) {
@@ -49,6 +58,32 @@ func TestValidateDeployment(t *testing.T) {
 			"",
 			true,
 		},
+		{
+			"Test running a deployment with both a branch and a tag",
+			args{
+				dep: deployment.Deployment{
+					User:       "test",
+					Repository: "test",
+					Branch:     "master",
+					Tag:        "v1.0.0",
+				},
+			},
+			"",
+			true,
+		},
+		{
+			"Test running a deployment with a negative fetch depth",
+			args{
+				dep: deployment.Deployment{
+					User:       "test",
+					Repository: "test",
+					Tag:        "v1.0.0",
+					Fetch:      deployment.Fetch{Depth: -1},
+				},
+			},
+			"",
+			true,
+		},
 		{
 			"Test running a deployment without a path",
 			args{
@@ -78,7 +113,7 @@ func TestValidateDeployment(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := deployment.Deploy(tt.args.dep)
+			err := deployment.Deploy(tt.args.dep, request.Event{})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
 				return
@@ -86,3 +121,1416 @@ func TestValidateDeployment(t *testing.T) {
 		})
 	}
 }
+
+func TestStepString(t *testing.T) {
+
+	type args struct {
+		step deployment.Step
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			"Test that a named step uses its name",
+			args{
+				step: deployment.Step{Name: "install", Run: "composer install"},
+			},
+			"install",
+		},
+		{
+			"Test that an unnamed built-in step uses the builtin",
+			args{
+				step: deployment.Step{Builtin: deployment.BuiltinGit},
+			},
+			"git",
+		},
+		{
+			"Test that an unnamed script step uses the script path",
+			args{
+				step: deployment.Step{Script: "/tmp/deploy.sh"},
+			},
+			"/tmp/deploy.sh",
+		},
+		{
+			"Test that an unnamed inline script step is not logged in full",
+			args{
+				step: deployment.Step{Script: "composer install\nphp artisan migrate\n"},
+			},
+			"inline script",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.args.step.String()
+			if got != tt.want {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindMatching(t *testing.T) {
+
+	type args struct {
+		list  []deployment.Deployment
+		event string
+		body  string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want []string
+	}{
+		{
+			"Test matching a deployment by repository",
+			args{
+				list: []deployment.Deployment{
+					{Repository: "git@github.com:klipitkas/other.git", Path: "/other"},
+					{Repository: "git@github.com:klipitkas/hooktail.git", Path: "/hooktail"},
+				},
+				body: `{"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			[]string{"/hooktail"},
+		},
+		{
+			"Test matching a deployment by clone url",
+			args{
+				list: []deployment.Deployment{
+					{Repository: "https://github.com/klipitkas/hooktail.git", Path: "/hooktail"},
+				},
+				body: `{"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git","clone_url":"https://github.com/klipitkas/hooktail.git"}}`,
+			},
+			[]string{"/hooktail"},
+		},
+		{
+			"Test matching a deployment with a generic webhook",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Path:       "/app",
+						Generic: &request.Generic{
+							Repository: "project",
+							Match:      map[string]string{"status": "success"},
+						},
+					},
+				},
+				body: `{"project":"git@ci.example.com:team/app.git","status":"success"}`,
+			},
+			[]string{"/app"},
+		},
+		{
+			"Test a generic webhook without a repository or conditions does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Path:       "/app",
+						Generic:    &request.Generic{},
+					},
+				},
+				body: `{"ref":"refs/heads/master","repository":{"ssh_url":"git@github.com:someone/else.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a generic webhook of the branch of the deployment matches",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Branch:     "master",
+						Path:       "/app",
+						Generic: &request.Generic{
+							Repository: "project",
+							Ref:        "ref",
+							SHA:        "sha",
+						},
+					},
+				},
+				body: `{"project":"git@ci.example.com:team/app.git","ref":"refs/heads/master","sha":"abc123"}`,
+			},
+			[]string{"/app"},
+		},
+		{
+			"Test a generic webhook of another branch does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Branch:     "master",
+						Path:       "/app",
+						Generic: &request.Generic{
+							Repository: "project",
+							Ref:        "ref",
+						},
+					},
+				},
+				body: `{"project":"git@ci.example.com:team/app.git","ref":"refs/heads/feature"}`,
+			},
+			nil,
+		},
+		{
+			"Test a generic webhook of the tag of the deployment matches",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Tag:        "v1.0.0",
+						Path:       "/app",
+						Generic: &request.Generic{
+							Repository: "project",
+							Ref:        "ref",
+						},
+					},
+				},
+				body: `{"project":"git@ci.example.com:team/app.git","ref":"refs/tags/v1.0.0"}`,
+			},
+			[]string{"/app"},
+		},
+		{
+			"Test a generic webhook without the configured commit does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Branch:     "master",
+						Path:       "/app",
+						Generic: &request.Generic{
+							Repository: "project",
+							SHA:        "sha",
+						},
+					},
+				},
+				body: `{"project":"git@ci.example.com:team/app.git","sha":null}`,
+			},
+			nil,
+		},
+		{
+			"Test a generic webhook with failing conditions does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@ci.example.com:team/app.git",
+						Path:       "/app",
+						Generic: &request.Generic{
+							Repository: "project",
+							Match:      map[string]string{"status": "success"},
+						},
+					},
+				},
+				body: `{"project":"git@ci.example.com:team/app.git","status":"failure"}`,
+			},
+			nil,
+		},
+		{
+			"Test matching a deployment on a successful workflow run",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"workflow_run"},
+						Workflows:  []string{"CI"},
+					},
+				},
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"CI","event":"push","head_branch":"master","head_sha":"abc","conclusion":"success"},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			[]string{"/hooktail"},
+		},
+		{
+			"Test a workflow run of a fork pull request from its master does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"workflow_run"},
+					},
+				},
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"CI","event":"pull_request","head_branch":"master","head_sha":"abc","conclusion":"success","head_repository":{"full_name":"fork/hooktail"}},"repository":{"full_name":"klipitkas/hooktail","ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a check suite of a pull request does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"check_suite"},
+					},
+				},
+				event: "check_suite",
+				body:  `{"action":"completed","check_suite":{"head_branch":"master","head_sha":"abc","conclusion":"success","pull_requests":[{"number":7}],"app":{"name":"GitHub Actions"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a check suite without a head branch does not match a deployment without a branch",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Tag:        "v1.0.0",
+						Path:       "/hooktail",
+						Events:     []string{"check_suite"},
+					},
+				},
+				event: "check_suite",
+				body:  `{"action":"completed","check_suite":{"head_branch":null,"head_sha":"abc","conclusion":"success","app":{"name":"GitHub Actions"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a failed workflow run does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"workflow_run"},
+					},
+				},
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"CI","head_branch":"master","head_sha":"abc","conclusion":"failure"},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a workflow run of another workflow does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"workflow_run"},
+						Workflows:  []string{"CI"},
+					},
+				},
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"Lint","head_branch":"master","head_sha":"abc","conclusion":"success"},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test matching a deployment on a successful check suite of its branch",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"check_suite"},
+					},
+				},
+				event: "check_suite",
+				body:  `{"action":"completed","check_suite":{"head_branch":"master","head_sha":"abc","conclusion":"success","app":{"name":"GitHub Actions"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			[]string{"/hooktail"},
+		},
+		{
+			"Test a push does not match a deployment triggered by workflow runs",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"workflow_run"},
+					},
+				},
+				event: "push",
+				body:  `{"ref":"refs/heads/master","repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test matching a deployment on a merged pull request with a label",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"pull_request"},
+						Labels:     []string{"deploy"},
+					},
+				},
+				event: "pull_request",
+				body:  `{"action":"closed","pull_request":{"number":1,"merged":true,"merge_commit_sha":"abc","base":{"ref":"master"},"labels":[{"name":"bug"},{"name":"deploy"}]},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			[]string{"/hooktail"},
+		},
+		{
+			"Test a closed pull request that was not merged does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"pull_request"},
+					},
+				},
+				event: "pull_request",
+				body:  `{"action":"closed","pull_request":{"number":1,"merged":false,"base":{"ref":"master"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a pull request merged into another branch does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"pull_request"},
+					},
+				},
+				event: "pull_request",
+				body:  `{"action":"closed","pull_request":{"number":1,"merged":true,"base":{"ref":"develop"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test a merged pull request without the label does not match",
+			args{
+				list: []deployment.Deployment{
+					{
+						Repository: "git@github.com:klipitkas/hooktail.git",
+						Branch:     "master",
+						Path:       "/hooktail",
+						Events:     []string{"pull_request"},
+						Labels:     []string{"deploy"},
+					},
+				},
+				event: "pull_request",
+				body:  `{"action":"closed","pull_request":{"number":1,"merged":true,"base":{"ref":"master"},"labels":[{"name":"bug"}]},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+		{
+			"Test all the deployments of a repository match",
+			args{
+				list: []deployment.Deployment{
+					{Name: "web", Repository: "git@github.com:klipitkas/hooktail.git", Path: "/web"},
+					{Name: "api", Repository: "git@github.com:klipitkas/hooktail.git", Path: "/api"},
+				},
+				body: `{"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			[]string{"/web", "/api"},
+		},
+		{
+			"Test no deployment matches another repository",
+			args{
+				list: []deployment.Deployment{
+					{Repository: "git@github.com:klipitkas/other.git", Path: "/other"},
+				},
+				body: `{"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{JSONBody: tt.args.body}
+			req.Headers = make(map[string][]string, 1)
+			req.Headers["X-Github-Event"] = []string{tt.args.event}
+			req.Parse([]byte(tt.args.body))
+			var got []string
+			for _, dep := range deployment.FindMatching(tt.args.list, req) {
+				got = append(got, dep.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+
+	type args struct {
+		dep     deployment.Deployment
+		message string
+		files   []string
+	}
+
+	tests := []struct {
+		name     string
+		args     args
+		wantSkip bool
+	}{
+		{
+			"Test a commit without directives is not skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api"},
+				message: "Fix the login form",
+			},
+			false,
+		},
+		{
+			"Test a commit with the default skip directive is skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api"},
+				message: "Update the readme [skip deploy]",
+			},
+			true,
+		},
+		{
+			"Test a commit with a ci skip directive is skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api"},
+				message: "[ci skip] Update the readme",
+			},
+			true,
+		},
+		{
+			"Test a commit with a custom skip directive is skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api", SkipPattern: `(?i)\[nodeploy\]`},
+				message: "Update the readme [NODEPLOY]",
+			},
+			true,
+		},
+		{
+			"Test a forced deployment is not skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api"},
+				message: "Hotfix [skip deploy] [deploy:api]",
+			},
+			false,
+		},
+		{
+			"Test forcing another deployment does not prevent the skip",
+			args{
+				dep:     deployment.Deployment{Name: "api"},
+				message: "Hotfix [skip deploy] [deploy:web]",
+			},
+			true,
+		},
+		{
+			"Test a commit that changes a matching path is not skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api", Paths: []string{"services/api/"}},
+				message: "Fix the api",
+				files:   []string{"services/api/cmd/main.go"},
+			},
+			false,
+		},
+		{
+			"Test a commit that only changes other paths is skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api", Paths: []string{"services/api/**", "go.mod"}},
+				message: "Fix the web",
+				files:   []string{"services/web/index.html", "README.md"},
+			},
+			true,
+		},
+		{
+			"Test a commit that only changes ignored paths is skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api", PathsIgnore: []string{"**/*.md", "docs/"}},
+				message: "Update the docs",
+				files:   []string{"docs/install.txt", "services/api/README.md"},
+			},
+			true,
+		},
+		{
+			"Test a commit without the changed files is not skipped",
+			args{
+				dep:     deployment.Deployment{Name: "api", Paths: []string{"services/api/"}},
+				message: "Fix the web",
+			},
+			false,
+		},
+		{
+			"Test a forced deployment is not skipped because of its paths",
+			args{
+				dep:     deployment.Deployment{Name: "api", Paths: []string{"services/api/"}},
+				message: "Redeploy [deploy:api]",
+				files:   []string{"services/web/index.html"},
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := request.Event{
+				After: "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Commits: []request.Commit{{
+					ID:       "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+					Message:  tt.args.message,
+					Modified: tt.args.files,
+				}},
+			}
+			got := deployment.SkipReason(tt.args.dep, event)
+			if (got != "") != tt.wantSkip {
+				t.Errorf("got = %q, wantSkip = %v", got, tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+
+	pusher := request.User{Name: "Kostas", Username: "klipitkas", Email: "Kostas@Example.com"}
+	sender := request.User{Username: "klipitkas"}
+
+	type args struct {
+		dep    deployment.Deployment
+		pusher request.User
+		sender request.User
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			"Test a deployment without lists allows everyone",
+			args{dep: deployment.Deployment{}, pusher: pusher, sender: sender},
+			false,
+		},
+		{
+			"Test an allowed email domain wildcard",
+			args{
+				dep:    deployment.Deployment{AllowedPushers: []string{"*@example.com"}},
+				pusher: pusher,
+			},
+			false,
+		},
+		{
+			"Test a pusher that is not allowed",
+			args{
+				dep:    deployment.Deployment{AllowedPushers: []string{"*@example.org"}},
+				pusher: pusher,
+			},
+			true,
+		},
+		{
+			"Test an allowed sender of an event without a pusher",
+			args{
+				dep:    deployment.Deployment{Events: []string{"workflow_run"}, AllowedSenders: []string{"klipitkas"}},
+				sender: sender,
+			},
+			false,
+		},
+		{
+			"Test an unknown pusher is not allowed",
+			args{dep: deployment.Deployment{AllowedPushers: []string{"*@example.com"}}},
+			true,
+		},
+		{
+			"Test the display name of a pusher is not matched",
+			args{
+				dep:    deployment.Deployment{AllowedPushers: []string{"klipitkas"}},
+				pusher: request.User{Name: "klipitkas", Username: "mallory"},
+			},
+			true,
+		},
+		{
+			"Test an allowed sender",
+			args{
+				dep:    deployment.Deployment{AllowedSenders: []string{"klipitkas"}},
+				sender: sender,
+			},
+			false,
+		},
+		{
+			"Test a sender that is not allowed",
+			args{
+				dep:    deployment.Deployment{AllowedSenders: []string{"octocat"}},
+				sender: sender,
+			},
+			true,
+		},
+		{
+			"Test a denied pusher takes precedence",
+			args{
+				dep: deployment.Deployment{
+					AllowedPushers: []string{"*@example.com"},
+					DeniedPushers:  []string{"klipitkas"},
+				},
+				pusher: pusher,
+			},
+			true,
+		},
+		{
+			"Test a denied sender",
+			args{
+				dep:    deployment.Deployment{DeniedSenders: []string{"klip*"}},
+				sender: sender,
+			},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := request.Event{Pusher: tt.args.pusher, Sender: tt.args.sender}
+			err := deployment.Authorize(tt.args.dep, event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+
+	list := []deployment.Deployment{
+		{Repository: "unnamed"},
+		{Name: "api", Repository: "api"},
+		{Name: "web", Repository: "web", Hook: "/hooks/4f1c2a9e"},
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"Test the root path serves deployments without a custom hook", "/", []string{"unnamed", "api"}},
+		{"Test the path of a named deployment", "/hooks/api", []string{"api"}},
+		{"Test the custom hook of a deployment", "/hooks/4f1c2a9e", []string{"web"}},
+		{"Test a deployment with a custom hook is not served by name", "/hooks/web", nil},
+		{"Test an unknown path", "/hooks/unknown", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, d := range deployment.Route(list, tt.path) {
+				got = append(got, d.Repository)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+
+	body := `{"ref":"refs/heads/master"}`
+	req := request.Request{
+		Headers: map[string][]string{
+			"X-Github-Event":      {"push"},
+			"X-Hub-Signature-256": {"sha256=" + common.Sha256Hmac(body, "new")},
+		},
+		JSONBody: body,
+	}
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		dep    deployment.Deployment
+		want   string
+		wantOk bool
+	}{
+		{
+			"Test the legacy secret",
+			deployment.Deployment{Secret: "new"},
+			"secret",
+			true,
+		},
+		{
+			"Test any of the secrets validates",
+			deployment.Deployment{Secret: "old", Secrets: []deployment.Secret{{Value: "other"}, {Value: "new"}}},
+			"secrets[1]",
+			true,
+		},
+		{
+			"Test a secret that has not expired yet",
+			deployment.Deployment{Secrets: []deployment.Secret{{Value: "new", Expires: future}}},
+			"secrets[0]",
+			true,
+		},
+		{
+			"Test an expired secret is not accepted",
+			deployment.Deployment{Secrets: []deployment.Secret{{Value: "new", Expires: past}}},
+			"",
+			false,
+		},
+		{
+			"Test a generic webhook without a secret or a token is not accepted",
+			deployment.Deployment{Generic: &request.Generic{Repository: "project"}},
+			"",
+			false,
+		},
+		{
+			"Test an unresolved secret is not accepted",
+			deployment.Deployment{SecretEnv: "HOOKTAIL_SECRET", Secrets: []deployment.Secret{{Env: "HOOKTAIL_SECRET"}}},
+			"",
+			false,
+		},
+		{
+			"Test wrong secrets are not accepted",
+			deployment.Deployment{Secret: "old", Secrets: []deployment.Secret{{Value: "other"}}},
+			"",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := deployment.VerifySignature(tt.dep, req)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("got = %q, %v, want = %q, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+
+	dir := t.TempDir()
+	scripts := map[string]struct {
+		body string
+		mode os.FileMode
+	}{
+		"shebang.sh":  {"#!/bin/bash\necho ok\n", 0700},
+		"plain.sh":    {"echo ok\n", 0700},
+		"readable.sh": {"#!/bin/bash\necho ok\n", 0600},
+		"empty.sh":    {"", 0700},
+		"nonexec.sh":  {"echo ok\n", 0600},
+	}
+	for name, s := range scripts {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s.body), s.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		script      string
+		interpreter string
+		wantName    string
+		wantArgs    []string
+	}{
+		{
+			"Test an executable script with a shebang is ran directly",
+			"shebang.sh",
+			"",
+			"shebang.sh",
+			nil,
+		},
+		{
+			"Test an executable script without a shebang uses the default interpreter",
+			"plain.sh",
+			"",
+			deployment.DefaultInterpreter,
+			[]string{"plain.sh"},
+		},
+		{
+			"Test an empty executable script uses the default interpreter",
+			"empty.sh",
+			"",
+			deployment.DefaultInterpreter,
+			[]string{"empty.sh"},
+		},
+		{
+			"Test a non-executable script with a shebang uses the default interpreter",
+			"readable.sh",
+			"",
+			deployment.DefaultInterpreter,
+			[]string{"readable.sh"},
+		},
+		{
+			"Test a non-executable script uses the default interpreter",
+			"nonexec.sh",
+			"",
+			deployment.DefaultInterpreter,
+			[]string{"nonexec.sh"},
+		},
+		{
+			"Test the interpreter overrides an executable script",
+			"shebang.sh",
+			"/bin/bash",
+			"/bin/bash",
+			[]string{"shebang.sh"},
+		},
+		{
+			"Test a missing script uses the default interpreter",
+			"missing.sh",
+			"",
+			deployment.DefaultInterpreter,
+			[]string{"missing.sh"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.script)
+			wantName := tt.wantName
+			if wantName == tt.script {
+				wantName = path
+			}
+			var wantArgs []string
+			for _, a := range tt.wantArgs {
+				wantArgs = append(wantArgs, filepath.Join(dir, a))
+			}
+			name, args := deployment.ScriptCommand(path, tt.interpreter)
+			if name != wantName || !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("got = %v %v, want = %v %v", name, args, wantName, wantArgs)
+			}
+		})
+	}
+}
+
+func TestWorkDir(t *testing.T) {
+
+	tests := []struct {
+		name string
+		dep  deployment.Deployment
+		want string
+	}{
+		{
+			"Test the deployment path is the default",
+			deployment.Deployment{Path: "/srv/app"},
+			"/srv/app",
+		},
+		{
+			"Test the work dir overrides the deployment path",
+			deployment.Deployment{Path: "/srv/app", WorkDir: "/srv"},
+			"/srv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deployment.WorkDir(tt.dep); got != tt.want {
+				t.Errorf("got = %q, want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanArgs(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		clean deployment.Clean
+		want  []string
+	}{
+		{
+			"Test untracked and ignored files are removed",
+			deployment.Clean{Enabled: true},
+			[]string{"clean", "-fdx"},
+		},
+		{
+			"Test a dry run only lists the files",
+			deployment.Clean{Enabled: true, DryRun: true},
+			[]string{"clean", "-ndx"},
+		},
+		{
+			"Test the excluded paths are kept",
+			deployment.Clean{Enabled: true, Exclude: []string{".env", "storage/"}},
+			[]string{"clean", "-fdx", "-e", ".env", "-e", "storage/"},
+		},
+		{
+			"Test the excluded paths are kept in a dry run",
+			deployment.Clean{Enabled: true, DryRun: true, Exclude: []string{"uploads/"}},
+			[]string{"clean", "-ndx", "-e", "uploads/"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deployment.CleanArgs(tt.clean); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRevision(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		dep         deployment.Deployment
+		commit      string
+		wantRev     string
+		wantRefspec string
+	}{
+		{
+			"Test a branch",
+			deployment.Deployment{Branch: "master"},
+			"",
+			"origin/master",
+			"+refs/heads/master:refs/remotes/origin/master",
+		},
+		{
+			"Test a tag",
+			deployment.Deployment{Branch: "master", Tag: "v1.0.0"},
+			"",
+			"refs/tags/v1.0.0",
+			"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+		},
+		{
+			"Test the commit of an event overrides the branch",
+			deployment.Deployment{Branch: "master"},
+			"0123456789abcdef",
+			"0123456789abcdef",
+			"+refs/heads/master:refs/remotes/origin/master",
+		},
+		{
+			"Test the commit of an event overrides the tag",
+			deployment.Deployment{Tag: "v1.0.0"},
+			"0123456789abcdef",
+			"0123456789abcdef",
+			"+refs/tags/v1.0.0:refs/tags/v1.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deployment.Revision(tt.dep, tt.commit); got != tt.wantRev {
+				t.Errorf("revision got = %q, want = %q", got, tt.wantRev)
+			}
+			if got := deployment.Refspec(tt.dep); got != tt.wantRefspec {
+				t.Errorf("refspec got = %q, want = %q", got, tt.wantRefspec)
+			}
+		})
+	}
+}
+
+func TestFetchArgs(t *testing.T) {
+
+	tests := []struct {
+		name string
+		dep  deployment.Deployment
+		want []string
+	}{
+		{
+			"Test every remote is updated by default",
+			deployment.Deployment{Branch: "master"},
+			[]string{"remote", "update"},
+		},
+		{
+			"Test a targeted fetch of a branch",
+			deployment.Deployment{Branch: "master", Fetch: deployment.Fetch{Targeted: true}},
+			[]string{"fetch", "--no-tags", "origin", "+refs/heads/master:refs/remotes/origin/master"},
+		},
+		{
+			"Test a targeted fetch of a tag",
+			deployment.Deployment{Tag: "v1.0.0", Fetch: deployment.Fetch{Targeted: true}},
+			[]string{"fetch", "--no-tags", "origin", "+refs/tags/v1.0.0:refs/tags/v1.0.0"},
+		},
+		{
+			"Test a depth implies a targeted fetch",
+			deployment.Deployment{Branch: "master", Fetch: deployment.Fetch{Depth: 1}},
+			[]string{"fetch", "--no-tags", "--depth", "1", "origin", "+refs/heads/master:refs/remotes/origin/master"},
+		},
+		{
+			"Test a filter implies a targeted fetch",
+			deployment.Deployment{Branch: "master", Fetch: deployment.Fetch{Filter: "blob:none"}},
+			[]string{"fetch", "--no-tags", "--filter=blob:none", "origin", "+refs/heads/master:refs/remotes/origin/master"},
+		},
+		{
+			"Test sparse paths imply a targeted fetch",
+			deployment.Deployment{Branch: "master", Fetch: deployment.Fetch{SparsePaths: []string{"services/api"}}},
+			[]string{"fetch", "--no-tags", "origin", "+refs/heads/master:refs/remotes/origin/master"},
+		},
+		{
+			"Test a depth and a filter",
+			deployment.Deployment{Tag: "v1.0.0", Fetch: deployment.Fetch{Depth: 10, Filter: "blob:none"}},
+			[]string{"fetch", "--no-tags", "--depth", "10", "--filter=blob:none", "origin", "+refs/tags/v1.0.0:refs/tags/v1.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deployment.FetchArgs(tt.dep); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPinnedCommit(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		event request.Event
+		want  string
+	}{
+		{
+			"Test a push deploys the head of the branch",
+			request.Event{Type: "push", Ref: "refs/heads/master", After: "abc123"},
+			"",
+		},
+		{
+			"Test a workflow run deploys its head commit",
+			request.Event{Type: "workflow_run", Ref: "refs/heads/master", After: "abc123"},
+			"abc123",
+		},
+		{
+			"Test a merged pull request deploys its merge commit",
+			request.Event{Type: "pull_request", Ref: "refs/heads/master", After: "abc123"},
+			"abc123",
+		},
+		{
+			"Test a generic webhook deploys the extracted commit",
+			request.Event{Type: "generic", Ref: "refs/heads/master", After: "abc123"},
+			"abc123",
+		},
+		{
+			"Test a generic webhook without a commit deploys the head of the branch",
+			request.Event{Type: "generic", Ref: "refs/heads/master"},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deployment.PinnedCommit(tt.event); got != tt.want {
+				t.Errorf("got = %q, want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateStep(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		step    deployment.Step
+		wantErr bool
+	}{
+		{
+			"Test a command",
+			deployment.Step{Run: "true", Dir: "/", Env: map[string]string{"A": "b"}, Timeout: time.Minute, Retries: 2},
+			false,
+		},
+		{
+			"Test the git builtin",
+			deployment.Step{Name: "update", Builtin: "git", ContinueOnError: true},
+			false,
+		},
+		{
+			"Test a step with both a command and a script should fail",
+			deployment.Step{Run: "true", Script: "/bin/true"},
+			true,
+		},
+		{
+			"Test an unknown builtin should fail",
+			deployment.Step{Builtin: "svn"},
+			true,
+		},
+		{
+			"Test negative retries should fail",
+			deployment.Step{Run: "true", Retries: -1},
+			true,
+		},
+		{
+			"Test the git builtin with an interpreter should fail",
+			deployment.Step{Builtin: "git", Interpreter: "/bin/bash"},
+			true,
+		},
+		{
+			"Test the git builtin with a dir should fail",
+			deployment.Step{Builtin: "git", Dir: "/"},
+			true,
+		},
+		{
+			"Test the git builtin with env should fail",
+			deployment.Step{Builtin: "git", Env: map[string]string{"A": "b"}},
+			true,
+		},
+		{
+			"Test the git builtin with a timeout should fail",
+			deployment.Step{Builtin: "git", Timeout: time.Minute},
+			true,
+		},
+		{
+			"Test the git builtin with retries should fail",
+			deployment.Step{Builtin: "git", Retries: 1},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := deployment.ValidateStep(tt.step)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunSteps(t *testing.T) {
+
+	// The git builtin must fail instead of updating any repository that
+	// contains the temporary directory.
+	gitDir, ok := os.LookupEnv("GIT_DIR")
+	os.Setenv("GIT_DIR", filepath.Join(os.TempDir(), "hooktail-missing.git"))
+	defer func() {
+		if ok {
+			os.Setenv("GIT_DIR", gitDir)
+		} else {
+			os.Unsetenv("GIT_DIR")
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		steps   func(dir string) []deployment.Step
+		want    string
+		wantErr bool
+	}{
+		{
+			"Test the steps run in order",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "echo one >> log"},
+					{Run: "echo two >> log"},
+				}
+			},
+			"one\ntwo\n",
+			false,
+		},
+		{
+			"Test a failed step stops the pipeline",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "echo one >> log"},
+					{Run: "exit 1"},
+					{Run: "echo three >> log"},
+				}
+			},
+			"one\n",
+			true,
+		},
+		{
+			"Test a failed step that may fail continues the pipeline",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "exit 1", ContinueOnError: true},
+					{Run: "echo two >> log"},
+				}
+			},
+			"two\n",
+			false,
+		},
+		{
+			"Test a failed step is retried until it succeeds",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "echo try >> log; [ $(wc -l < log) -ge 3 ]", Retries: 3},
+				}
+			},
+			"try\ntry\ntry\n",
+			false,
+		},
+		{
+			"Test a step fails after its retries",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "echo try >> log; exit 1", Retries: 1},
+				}
+			},
+			"try\ntry\n",
+			true,
+		},
+		{
+			"Test a step timeout fails the step",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "sleep 5; echo late >> log", Timeout: 100 * time.Millisecond},
+				}
+			},
+			"",
+			true,
+		},
+		{
+			"Test the dir and env of a step",
+			func(dir string) []deployment.Step {
+				sub := filepath.Join(dir, "sub")
+				if err := os.Mkdir(sub, 0700); err != nil {
+					t.Fatal(err)
+				}
+				return []deployment.Step{
+					{Run: "echo $GREETING > ../log; pwd >> ../log", Dir: sub, Env: map[string]string{"GREETING": "hello"}},
+				}
+			},
+			"hello\n<dir>/sub\n",
+			false,
+		},
+		{
+			"Test the steps before the git builtin run before it and those after it do not run when it fails",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Run: "echo before >> log"},
+					{Builtin: "git"},
+					{Run: "echo after >> log"},
+				}
+			},
+			"before\n",
+			true,
+		},
+		{
+			"Test the git builtin runs first when it is the first step",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Builtin: "git"},
+					{Run: "echo after >> log"},
+				}
+			},
+			"",
+			true,
+		},
+		{
+			"Test the steps after a failed git builtin that may fail run",
+			func(dir string) []deployment.Step {
+				return []deployment.Step{
+					{Builtin: "git", ContinueOnError: true},
+					{Run: "echo after >> log"},
+				}
+			},
+			"after\n",
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := filepath.EvalSymlinks(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := deployment.Deployment{Branch: "master", Path: dir, Steps: tt.steps(dir)}
+			err = deployment.RunSteps(d)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+			got, _ := ioutil.ReadFile(filepath.Join(dir, "log"))
+			want := strings.ReplaceAll(tt.want, "<dir>", dir)
+			if string(got) != want {
+				t.Errorf("got = %q, want = %q", got, want)
+			}
+		})
+	}
+}
+
+func TestInlineScript(t *testing.T) {
+
+	// The temporary scripts are written to their own directory, which
+	// must be empty after each run.
+	tmp := t.TempDir()
+	tmpDir, ok := os.LookupEnv("TMPDIR")
+	os.Setenv("TMPDIR", tmp)
+	defer func() {
+		if ok {
+			os.Setenv("TMPDIR", tmpDir)
+		} else {
+			os.Unsetenv("TMPDIR")
+		}
+	}()
+
+	// Only root can run the scripts as the owner of the files.
+	username := ""
+	if os.Getuid() == 0 {
+		username = "root"
+	}
+	owner := "root"
+	if u, err := user.Current(); err == nil {
+		owner = u.Username
+	}
+
+	run := func(d deployment.Deployment, body string) error {
+		return deployment.RunScript(d, body)
+	}
+	step := func(d deployment.Deployment, body string) error {
+		return deployment.RunStep(d, deployment.Step{Script: body})
+	}
+
+	tests := []struct {
+		name    string
+		run     func(d deployment.Deployment, body string) error
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{
+			"Test an inline script is private and ran with the interpreter",
+			run,
+			"stat -c '%a %U' \"$0\" > log\n",
+			"600 " + owner + "\n",
+			false,
+		},
+		{
+			"Test an inline script with a shebang is private and executable",
+			run,
+			"#!/bin/sh\nstat -c '%a %U' \"$0\" > log\n",
+			"700 " + owner + "\n",
+			false,
+		},
+		{
+			"Test a failing inline script is removed",
+			run,
+			"echo failed > log\nexit 1\n",
+			"failed\n",
+			true,
+		},
+		{
+			"Test an inline script of a step is private and ran with the interpreter",
+			step,
+			"stat -c '%a %U' \"$0\" > log\n",
+			"600 " + owner + "\n",
+			false,
+		},
+		{
+			"Test an inline script of a step with a shebang is private and executable",
+			step,
+			"#!/bin/sh\nstat -c '%a %U' \"$0\" > log\n",
+			"700 " + owner + "\n",
+			false,
+		},
+		{
+			"Test a failing inline script of a step is removed",
+			step,
+			"echo failed > log\nexit 1\n",
+			"failed\n",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			d := deployment.Deployment{User: username, Path: dir}
+			err := tt.run(d, tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+			got, _ := ioutil.ReadFile(filepath.Join(dir, "log"))
+			if string(got) != tt.want {
+				t.Errorf("got = %q, want = %q", got, tt.want)
+			}
+			left, err := filepath.Glob(filepath.Join(tmp, "hooktail-*"))
+			if err != nil || len(left) > 0 {
+				t.Errorf("temporary scripts left behind: %v", left)
+			}
+		})
+	}
+}
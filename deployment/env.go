@@ -0,0 +1,28 @@
+package deployment
+
+import (
+	"strconv"
+
+	"github.com/klipitkas/hooktail/request"
+)
+
+// eventEnv returns the environment variables that expose the event
+// that triggered a deployment to its scripts.
+func eventEnv(event request.Event) []string {
+	env := []string{
+		"HOOKTAIL_PROVIDER=" + event.Provider,
+		"HOOKTAIL_EVENT=" + event.Type,
+		"HOOKTAIL_REF=" + event.Ref,
+		"HOOKTAIL_BEFORE=" + event.Before,
+		"HOOKTAIL_AFTER=" + event.After,
+		"HOOKTAIL_PUSHER=" + event.Pusher.Username,
+	}
+	if event.Type == request.EventPullRequest {
+		env = append(env,
+			"HOOKTAIL_PR_NUMBER="+strconv.Itoa(event.PullRequest.Number),
+			"HOOKTAIL_PR_TITLE="+event.PullRequest.Title,
+			"HOOKTAIL_PR_AUTHOR="+event.PullRequest.Author,
+		)
+	}
+	return env
+}
@@ -0,0 +1,45 @@
+package deployment
+
+// ScriptCommand exposes scriptCommand for testing.
+var ScriptCommand = scriptCommand
+
+// PinnedCommit exposes pinnedCommit for testing.
+var PinnedCommit = pinnedCommit
+
+// WorkDir exposes the working directory of the scripts for testing.
+func WorkDir(d Deployment) string {
+	return d.workDir()
+}
+
+// CleanArgs exposes the arguments of the git clean command for testing.
+func CleanArgs(c Clean) []string {
+	return c.args()
+}
+
+// Revision exposes the revision that a deployment of the commit resets to.
+func Revision(d Deployment, commit string) string {
+	d.commit = commit
+	return d.revision()
+}
+
+// Refspec exposes the refspec of the targeted fetch for testing.
+func Refspec(d Deployment) string {
+	return d.refspec()
+}
+
+// FetchArgs exposes the arguments of the git fetch command for testing.
+func FetchArgs(d Deployment) []string {
+	return d.fetchArgs()
+}
+
+// ValidateStep exposes validateStep for testing.
+var ValidateStep = validateStep
+
+// RunSteps exposes runSteps for testing.
+var RunSteps = runSteps
+
+// RunScript exposes runScript for testing.
+var RunScript = runScript
+
+// RunStep exposes runStep for testing.
+var RunStep = runStep
@@ -0,0 +1,75 @@
+package deployment
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/klipitkas/hooktail/common"
+)
+
+// Fetch contains the options that limit what is transferred from
+// the remote during a deployment.
+type Fetch struct {
+	// Only fetch the configured branch or tag instead of running
+	// "git remote update" for every remote and branch.
+	Targeted bool `yaml:"targeted,omitempty" json:"targeted,omitempty"`
+	// Limit the fetched history to the given number of commits.
+	Depth int `yaml:"depth,omitempty" json:"depth,omitempty"`
+	// The partial clone filter, e.g. "blob:none".
+	Filter string `yaml:"filter,omitempty" json:"filter,omitempty"`
+	// The paths that will be checked out using sparse-checkout.
+	SparsePaths []string `yaml:"sparse_paths,omitempty" json:"sparse_paths,omitempty"`
+}
+
+// targeted reports whether only the deployed ref should be fetched.
+// Any of the shallow or partial options implies a targeted fetch.
+func (f Fetch) targeted() bool {
+	return f.Targeted || f.Depth > 0 || f.Filter != "" || len(f.SparsePaths) > 0
+}
+
+// revision returns the git revision that the deployment resets to.
+func (d Deployment) revision() string {
+	if d.commit != "" {
+		return d.commit
+	}
+	if d.Tag != "" {
+		return "refs/tags/" + d.Tag
+	}
+	return "origin/" + d.Branch
+}
+
+// refspec returns the refspec that fetches only the deployed ref.
+func (d Deployment) refspec() string {
+	if d.Tag != "" {
+		return "+refs/tags/" + d.Tag + ":refs/tags/" + d.Tag
+	}
+	return "+refs/heads/" + d.Branch + ":refs/remotes/origin/" + d.Branch
+}
+
+// fetchArgs returns the arguments of the git command that updates the
+// local repository.
+func (d Deployment) fetchArgs() []string {
+	if !d.Fetch.targeted() {
+		return []string{"remote", "update"}
+	}
+	args := []string{"fetch", "--no-tags"}
+	if d.Fetch.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(d.Fetch.Depth))
+	}
+	if d.Fetch.Filter != "" {
+		args = append(args, "--filter="+d.Fetch.Filter)
+	}
+	return append(args, "origin", d.refspec())
+}
+
+// fetch updates the local repository from the remote, either fully
+// or only with what the deployment needs.
+func fetch(d Deployment) error {
+	if _, err := common.ExecuteCommand("git", d.User, d.Path, d.fetchArgs()...); err != nil {
+		if !d.Fetch.targeted() {
+			return fmt.Errorf("git remote update: %v", err)
+		}
+		return fmt.Errorf("git fetch %v: %v", d.refspec(), err)
+	}
+	return nil
+}
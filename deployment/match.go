@@ -0,0 +1,90 @@
+package deployment
+
+import (
+	"github.com/klipitkas/hooktail/request"
+)
+
+// defaultEvents are the event types that trigger a deployment when no
+// events are configured.
+var defaultEvents = []string{request.EventPush, "tag_push", request.ProviderGeneric}
+
+// matchesEvent reports whether the type and the filters of a deployment
+// accept an event. Deployments triggered by CI only accept successful
+// runs of their branch in their own repository and, when configured, of
+// their workflows, and those triggered by pull requests only accept the
+// ones merged into their branch with, when configured, any of their
+// labels.
+func matchesEvent(d Deployment, event request.Event) bool {
+	events := d.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	if !contains(events, event.Type) {
+		return false
+	}
+	switch event.Type {
+	case request.EventWorkflowRun, request.EventCheckSuite:
+		if event.Action != "completed" || event.Conclusion != "success" {
+			return false
+		}
+		if event.Branch() == "" || event.Branch() != d.Branch {
+			return false
+		}
+		if len(d.Workflows) > 0 && !contains(d.Workflows, event.Workflow) {
+			return false
+		}
+	case request.EventPullRequest:
+		if event.Action != "closed" || !event.PullRequest.Merged {
+			return false
+		}
+		if event.Branch() != d.Branch {
+			return false
+		}
+		if len(d.Labels) > 0 && !containsAny(event.PullRequest.Labels, d.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesRef reports whether the reference of an event is the branch
+// or the tag of a deployment.
+func matchesRef(d Deployment, event request.Event) bool {
+	if d.Tag != "" {
+		return event.Ref == "refs/tags/"+d.Tag
+	}
+	return event.Branch() != "" && event.Branch() == d.Branch
+}
+
+// pinnedCommit returns the commit that an event requires to be deployed
+// instead of the head of the branch, e.g. the head_sha of a workflow run,
+// the merge commit of a pull request or the commit extracted by a generic
+// webhook.
+func pinnedCommit(event request.Event) string {
+	switch event.Type {
+	case request.EventWorkflowRun, request.EventCheckSuite, request.EventPullRequest,
+		request.ProviderGeneric:
+		return event.After
+	}
+	return ""
+}
+
+// contains reports whether a list contains a value.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny reports whether a list contains any of the values.
+func containsAny(list []string, values []string) bool {
+	for _, v := range values {
+		if contains(list, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package deployment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/klipitkas/hooktail/common"
+	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/request"
+)
+
+// zeroSHA is the commit SHA that forges send for created or deleted refs.
+const zeroSHA = "0000000000000000000000000000000000000000"
+
+// hasPathFilters reports whether the deployment only runs for changes
+// of specific paths.
+func (d Deployment) hasPathFilters() bool {
+	return len(d.Paths) > 0 || len(d.PathsIgnore) > 0
+}
+
+// matchesPaths reports whether any of the changed files triggers the
+// deployment. A file triggers it when it matches any of the paths, or
+// when there are no paths, and does not match any of the ignored paths.
+func (d Deployment) matchesPaths(files []string) bool {
+	for _, f := range files {
+		if matchesAnyGlob(d.PathsIgnore, f) {
+			continue
+		}
+		if len(d.Paths) == 0 || matchesAnyGlob(d.Paths, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathSkipReason returns why a deployment should be skipped because of
+// its path filters, or an empty string when it should run.
+func pathSkipReason(d Deployment, files []string) string {
+	if !d.hasPathFilters() || d.matchesPaths(files) {
+		return ""
+	}
+	return fmt.Sprintf("none of the %d changed files matches the paths", len(files))
+}
+
+// changedFiles returns the files changed by an event. The files of the
+// payload are used when they are complete, otherwise the repository is
+// fetched and compared between the commits before and after the push.
+// The fetch is marked on the deployment so that it is not repeated, the
+// before scripts and steps thus run after it. The boolean is false when
+// the changed files cannot be determined, e.g. when a shallow or tag
+// only fetch does not contain the commit before the push.
+func changedFiles(d *Deployment, event request.Event) ([]string, bool, error) {
+	if files, complete := event.Files(); complete {
+		return files, true, nil
+	}
+	if event.Before == "" || event.Before == zeroSHA || event.After == "" {
+		return nil, false, nil
+	}
+	if err := fetch(*d); err != nil {
+		return nil, false, err
+	}
+	d.fetched = true
+	args := []string{"diff", "--name-only", event.Before + ".." + event.After}
+	out, err := common.ExecuteCommand("git", d.User, d.Path, args...)
+	if err != nil {
+		logging.Log.Warnf("Cannot compare %v with %v, deploying %v regardless "+
+			"of its paths: %v", shortSHA(event.Before), shortSHA(event.After),
+			d.Repository, err)
+		return nil, false, nil
+	}
+	var files []string
+	for _, f := range strings.Split(out, "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, true, nil
+}
+
+// matchesAnyGlob reports whether a path matches any of the patterns.
+func matchesAnyGlob(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if matchGlob(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether a path matches a glob pattern, where "*"
+// and "?" do not cross directories, "**" does and a trailing "/"
+// matches everything inside a directory.
+func matchGlob(pattern string, path string) bool {
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(path)
+}
+
+// globRegexp compiles a glob pattern to a regular expression.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
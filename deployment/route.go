@@ -0,0 +1,50 @@
+package deployment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// HookPrefix is the prefix of the webhook URLs of named deployments.
+const HookPrefix = "/hooks/"
+
+// namePattern matches the names that can be used in URLs and in
+// "[deploy:<name>]" directives.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// HookPath returns the path of the webhook URL of the deployment, or
+// an empty string for unnamed deployments without a custom hook.
+func (d Deployment) HookPath() string {
+	if d.Hook != "" {
+		return d.Hook
+	}
+	if d.Name != "" {
+		return HookPrefix + d.Name
+	}
+	return ""
+}
+
+// Route returns the deployments that are served on a path. The root
+// path serves every deployment without a custom hook, any other path
+// only the deployment whose webhook URL it is.
+func Route(list []Deployment, path string) []Deployment {
+	var routed []Deployment
+	for _, d := range list {
+		if path == "/" && d.Hook == "" || path != "/" && d.HookPath() == path {
+			routed = append(routed, d)
+		}
+	}
+	return routed
+}
+
+// validateRoute checks the name and the custom hook of a deployment.
+func validateRoute(d Deployment) error {
+	if d.Name != "" && !namePattern.MatchString(d.Name) {
+		return fmt.Errorf("invalid name %q", d.Name)
+	}
+	if d.Hook != "" && (!strings.HasPrefix(d.Hook, "/") || d.Hook == "/") {
+		return fmt.Errorf("invalid hook path %q", d.Hook)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package deployment
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/klipitkas/hooktail/common"
+)
+
+// isInline reports whether a script is an inline body rather than the
+// path of a file. YAML block scalars ("|") always end with a newline,
+// so any multi-line value is treated as an inline script.
+func isInline(script string) bool {
+	return strings.Contains(script, "\n")
+}
+
+// validateScript checks that a file based script exists.
+func validateScript(script string) error {
+	if script == "" || isInline(script) {
+		return nil
+	}
+	if _, err := os.Stat(script); os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// workDir returns the working directory of the deployment scripts.
+func (d Deployment) workDir() string {
+	if d.WorkDir != "" {
+		return d.WorkDir
+	}
+	return d.Path
+}
+
+// scriptCommand returns the command that runs a script file. Without an
+// interpreter, executable scripts with a shebang are ran directly so that
+// it is honored and any other script is ran with /bin/sh, since running
+// an executable script without a shebang fails with ENOEXEC.
+func scriptCommand(path string, interpreter string) (string, []string) {
+	if interpreter != "" {
+		return interpreter, []string{path}
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode()&0111 != 0 && hasShebang(path) {
+		return path, nil
+	}
+	return DefaultInterpreter, []string{path}
+}
+
+// hasShebang reports whether a file starts with "#!".
+func hasShebang(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	prefix := make([]byte, 2)
+	if _, err := io.ReadFull(f, prefix); err != nil {
+		return false
+	}
+	return string(prefix) == "#!"
+}
+
+// writeScript writes an inline script body to a private temporary file
+// that is owned by the deployment user and returns the path of the file.
+// Bodies starting with a shebang are made executable. The caller is
+// responsible for removing the file.
+func writeScript(body string, username string) (string, error) {
+	f, err := ioutil.TempFile("", "hooktail-*.sh")
+	if err != nil {
+		return "", fmt.Errorf("create temporary script: %v", err)
+	}
+	path := f.Name()
+
+	if _, err := f.WriteString(body); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("write temporary script %s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("close temporary script %s: %v", path, err)
+	}
+	var mode os.FileMode = 0600
+	if strings.HasPrefix(body, "#!") {
+		mode = 0700
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("chmod temporary script %s: %v", path, err)
+	}
+
+	if username != "" {
+		credentials, err := common.UserCredentialsFromUsername(username)
+		if err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("get user credentials %q: %v", username, err)
+		}
+		if err := os.Chown(path, int(credentials.Uid), int(credentials.Gid)); err != nil {
+			os.Remove(path)
+			return "", fmt.Errorf("chown temporary script %s: %v", path, err)
+		}
+	}
+
+	return path, nil
+}
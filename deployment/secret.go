@@ -0,0 +1,89 @@
+package deployment
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/request"
+)
+
+// Secret is one of the secrets of a deployment, which allows rotating
+// them without a window where the webhook and the server disagree.
+type Secret struct {
+	// The value of the secret.
+	Value string `yaml:"value,omitempty" json:"value,omitempty"`
+	// The environment variable that holds the value.
+	Env string `yaml:"env,omitempty" json:"env,omitempty"`
+	// The file that holds the value.
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// The time after which the secret is no longer accepted.
+	Expires time.Time `yaml:"expires,omitempty" json:"expires,omitempty"`
+}
+
+// Expired reports whether the secret is no longer accepted.
+func (s Secret) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+// labeledSecret is a secret along with where it is configured.
+type labeledSecret struct {
+	Secret
+	label string
+}
+
+// secrets returns the secret and the list of secrets of a deployment.
+func (d Deployment) secrets() []labeledSecret {
+	var list []labeledSecret
+	if d.Secret != "" {
+		list = append(list, labeledSecret{Secret{Value: d.Secret}, "secret"})
+	}
+	for i, s := range d.Secrets {
+		list = append(list, labeledSecret{s, fmt.Sprintf("secrets[%d]", i)})
+	}
+	return list
+}
+
+// HasSecret reports whether requests of the deployment are signed,
+// including by secrets whose reference could not be resolved.
+func (d Deployment) HasSecret() bool {
+	return d.Secret != "" || d.SecretEnv != "" || d.SecretFile != "" || len(d.Secrets) > 0
+}
+
+// VerifySignature checks the signature of a request against every
+// secret of a deployment that has not expired. It returns the secret
+// that matched, e.g. "secrets[1]", so that old secrets can be removed
+// once they are no longer used.
+func VerifySignature(d Deployment, r request.Request) (string, bool) {
+	if !d.HasSecret() {
+		// Generic webhooks may only check their bearer token, nothing
+		// else identifies the sender.
+		if d.Generic == nil || d.Generic.Token == "" {
+			return "", false
+		}
+		return "token", r.HasValidSignature("")
+	}
+	now := time.Now()
+	for _, s := range d.secrets() {
+		if s.Value == "" || !r.HasValidSignature(s.Value) {
+			continue
+		}
+		if s.Expired(now) {
+			logging.Log.Warnf("Request for %v is signed with %v, which expired at %v",
+				d.Repository, s.label, s.Expires)
+			continue
+		}
+		return s.label, true
+	}
+	return "", false
+}
+
+// validateSecrets checks the list of secrets of a deployment.
+func validateSecrets(d Deployment) error {
+	for i, s := range d.Secrets {
+		if s.Value == "" {
+			return fmt.Errorf("empty value of secrets[%d]", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package deployment
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/request"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSkipPattern matches the commit message directives that skip a
+// deployment when no pattern is configured.
+const DefaultSkipPattern = `\[(skip deploy|deploy skip|skip ci|ci skip)\]`
+
+// forcePattern matches the "[deploy:<name>]" directives that force a
+// deployment to run.
+var forcePattern = regexp.MustCompile(`\[deploy:([^\]\s]+)\]`)
+
+// skipPattern returns the compiled skip pattern of a deployment.
+func (d Deployment) skipPattern() (*regexp.Regexp, error) {
+	pattern := d.SkipPattern
+	if pattern == "" {
+		pattern = DefaultSkipPattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// Forced reports whether the head commit of an event forces the
+// deployment to run with a "[deploy:<name>]" directive.
+func Forced(d Deployment, event request.Event) bool {
+	if d.Name == "" {
+		return false
+	}
+	for _, m := range forcePattern.FindAllStringSubmatch(event.Head().Message, -1) {
+		if m[1] == d.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipReason returns why a deployment should be skipped for an event, or
+// an empty string when it should run. Deployments are skipped when the
+// head commit message matches their skip pattern or when none of the
+// changed files of the payload matches their paths, unless they are
+// forced. Incomplete payloads are checked by Deploy after fetching.
+func SkipReason(d Deployment, event request.Event) string {
+	if Forced(d, event) {
+		return ""
+	}
+	pattern, err := d.skipPattern()
+	if err != nil {
+		return ""
+	}
+	head := event.Head()
+	if directive := pattern.FindString(head.Message); directive != "" {
+		return fmt.Sprintf("commit %v contains %q", shortSHA(head.ID), directive)
+	}
+	if files, complete := event.Files(); complete {
+		return pathSkipReason(d, files)
+	}
+	return ""
+}
+
+// shortSHA returns the abbreviated form of a commit SHA.
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return strings.TrimSpace(sha)
+}
+
+// LogSkip logs a skipped deployment with its reason and records it in
+// the audit log.
+func LogSkip(d Deployment, event request.Event, reason string) {
+	logging.Log.Warnf("Skipping deployment of %v: %v", d.Repository, reason)
+	logging.Audit("deployment skipped", log.Fields{
+		"repository": d.Repository,
+		"deployment": d.Name,
+		"ref":        event.Ref,
+		"commit":     event.After,
+		"reason":     reason,
+	})
+}
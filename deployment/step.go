@@ -0,0 +1,169 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/klipitkas/hooktail/common"
+	"github.com/klipitkas/hooktail/logging"
+)
+
+// BuiltinGit is the built-in step that updates the repository.
+const BuiltinGit = "git"
+
+// DefaultInterpreter is the interpreter used for commands and scripts.
+const DefaultInterpreter = "/bin/sh"
+
+// Step is a single step of a deployment pipeline.
+type Step struct {
+	// The name of the step, used in the logs.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// A built-in step, currently only "git" is supported.
+	Builtin string `yaml:"builtin,omitempty" json:"builtin,omitempty"`
+	// An inline command that will be executed.
+	Run string `yaml:"run,omitempty" json:"run,omitempty"`
+	// The path of a script that will be executed.
+	Script string `yaml:"script,omitempty" json:"script,omitempty"`
+	// The interpreter of the command or script, defaults to the
+	// interpreter of the deployment.
+	Interpreter string `yaml:"interpreter,omitempty" json:"interpreter,omitempty"`
+	// The working directory, defaults to the deployment work dir.
+	Dir string `yaml:"dir,omitempty" json:"dir,omitempty"`
+	// Any additional environment variables.
+	Env map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	// The maximum duration of a single attempt, e.g. "5m".
+	Timeout time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// Continue with the next step when this one fails.
+	ContinueOnError bool `yaml:"continue_on_error,omitempty" json:"continue_on_error,omitempty"`
+	// The number of times a failed step is retried.
+	Retries int `yaml:"retries,omitempty" json:"retries,omitempty"`
+}
+
+// String returns the name of the step used in logs and errors.
+func (s Step) String() string {
+	switch {
+	case s.Name != "":
+		return s.Name
+	case s.Builtin != "":
+		return s.Builtin
+	case isInline(s.Script):
+		return "inline script"
+	case s.Script != "":
+		return s.Script
+	}
+	return s.Run
+}
+
+// validateStep validates a single step of a deployment.
+func validateStep(s Step) error {
+	kinds := 0
+	for _, v := range []string{s.Builtin, s.Run, s.Script} {
+		if v != "" {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return errors.New("exactly one of builtin, run or script is required")
+	}
+	if s.Builtin != "" && s.Builtin != BuiltinGit {
+		return fmt.Errorf("unknown builtin %q", s.Builtin)
+	}
+	// The built-in steps run git commands of the deployment itself.
+	if s.Builtin != "" && (s.Interpreter != "" || s.Dir != "" || len(s.Env) > 0 ||
+		s.Timeout != 0 || s.Retries != 0) {
+		return errors.New("interpreter, dir, env, timeout and retries cannot be used with a builtin")
+	}
+	if s.Retries < 0 {
+		return fmt.Errorf("invalid retries %d", s.Retries)
+	}
+	if err := validateScript(s.Script); err != nil {
+		return fmt.Errorf("check script %s existence: %v", s.Script, err)
+	}
+	if s.Dir != "" {
+		if _, err := os.Stat(s.Dir); os.IsNotExist(err) {
+			return fmt.Errorf("check dir %s existence: %v", s.Dir, err)
+		}
+	}
+	return nil
+}
+
+// command returns the command that executes a non built-in step.
+func (s Step) command(d Deployment) common.Command {
+	cmd := common.Command{
+		User:    d.User,
+		Dir:     s.Dir,
+		Env:     append([]string{}, d.env...),
+		Timeout: s.Timeout,
+	}
+	if cmd.Dir == "" {
+		cmd.Dir = d.workDir()
+	}
+	interpreter := s.Interpreter
+	if interpreter == "" {
+		interpreter = d.Interpreter
+	}
+	if s.Run != "" {
+		cmd.Name = interpreter
+		if cmd.Name == "" {
+			cmd.Name = DefaultInterpreter
+		}
+		cmd.Args = []string{"-c", s.Run}
+	} else {
+		cmd.Name, cmd.Args = scriptCommand(s.Script, interpreter)
+	}
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+s.Env[k])
+	}
+	return cmd
+}
+
+// runStep runs a single step once.
+func runStep(d Deployment, s Step) error {
+	if s.Builtin == BuiltinGit {
+		return run(d)
+	}
+	if isInline(s.Script) {
+		tmp, err := writeScript(s.Script, d.User)
+		if err != nil {
+			return fmt.Errorf("inline script: %v", err)
+		}
+		defer os.Remove(tmp)
+		s.Script = tmp
+	}
+	_, err := s.command(d).Execute()
+	return err
+}
+
+// runSteps runs the steps of a deployment in order, retrying failed
+// steps and skipping those that are allowed to fail.
+func runSteps(d Deployment) error {
+	for i, s := range d.Steps {
+		var err error
+		for attempt := 0; attempt <= s.Retries; attempt++ {
+			if attempt > 0 {
+				logging.Log.Warnf("Retrying step %v (%d/%d): %v", s, attempt,
+					s.Retries, err)
+			}
+			if err = runStep(d, s); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			if s.ContinueOnError {
+				logging.Log.Warnf("Step %v failed, continuing: %v", s, err)
+				continue
+			}
+			return fmt.Errorf("step %d (%v): %v", i+1, s, err)
+		}
+		logging.Log.Printf("Finished running step %v.", s)
+	}
+	return nil
+}
@@ -35,3 +35,9 @@ func init() {
 	// Set the default formatter options.
 	Log.SetFormatter(&log.TextFormatter{ForceColors: true})
 }
+
+// Audit records a decision about a request, e.g. a skipped or a rejected
+// deployment, as a structured entry of the log.
+func Audit(action string, fields log.Fields) {
+	Log.WithFields(fields).WithField("audit", action).Warnf("Audit: %v", action)
+}
@@ -3,24 +3,23 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	config "github.com/klipitkas/hooktail/config"
 	deployment "github.com/klipitkas/hooktail/deployment"
 	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/network"
 	request "github.com/klipitkas/hooktail/request"
+	log "github.com/sirupsen/logrus"
 )
 
-// The content type we want
-const (
-	ApplicationJSON           string = "application/json"
-	ApplicationFormURLEncoded string = "application/x-www-form-urlencoded"
-)
-
-var conf config.Config
-
 func main() {
 	// The path to the configuration file.
 	configPath := ""
@@ -29,85 +28,309 @@ func main() {
 		"The configuration file path.")
 	flag.Parse()
 
-	// The configuration struct.
-	if err := config.Parse(&conf, configPath); err != nil {
-		logging.Log.Fatalf("parsing configuration: %v", err)
+	// The subcommand, its flags can also follow it, e.g.
+	// "hooktail check -config config.yml".
+	switch command := flag.Arg(0); command {
+	case "":
+	case "check":
+		flag.CommandLine.Parse(flag.Args()[1:])
+		os.Exit(check(configPath))
+	default:
+		logging.Log.Fatalf("unknown command %q", command)
+	}
+
+	// Validate every deployment before accepting any request.
+	s, errs := load(configPath, nil)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logging.Log.Errorf("check configuration: %v", err)
+		}
+		logging.Log.Fatalf("invalid configuration %v: %d problems found",
+			configPath, len(errs))
+	}
+	current.Store(s)
+	conf := s.conf
+
+	// Reload the configuration on SIGHUP and when its file changes.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			logging.Log.Printf("Received SIGHUP, reloading configuration %v", configPath)
+			reload(configPath)
+		}
+	}()
+	if conf.WatchInterval > 0 {
+		files := func() []string { return currentState().conf.Files(configPath) }
+		go config.Watch(files, conf.WatchInterval, nil, func() {
+			logging.Log.Printf("Configuration %v changed, reloading it", configPath)
+			reload(configPath)
+		})
 	}
 
 	// The list of request handlers.
-	http.HandleFunc("/", handleRequest)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleRequest)
 
 	// Log the server start.
 	logging.Log.Printf("Starting HTTP server on port: %v", conf.Port)
 
-	// The port in string format.
-	portStr := fmt.Sprintf("%d", conf.Port)
-
 	// The server configuration.
-	if err := http.ListenAndServe(":"+portStr, nil); err != nil {
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", conf.Port),
+		Handler:           mux,
+		ReadTimeout:       conf.ReadTimeout,
+		ReadHeaderTimeout: conf.ReadHeaderTimeout,
+		WriteTimeout:      conf.WriteTimeout,
+		IdleTimeout:       conf.IdleTimeout,
+	}
+	if err := server.ListenAndServe(); err != nil {
 		logging.Log.Fatalf("listen on port %d failed: %v", conf.Port, err)
 	}
 }
 
 func handleRequest(w http.ResponseWriter, req *http.Request) {
 
-	// The body of the request.
-	body, err := ioutil.ReadAll(req.Body)
+	// Webhooks are only delivered with POST requests.
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("Only POST requests are accepted."))
+		return
+	}
 
-	// Extract the content type from the headers.
-	contentType := strings.ToLower(req.Header.Get("Content-Type"))
-	if contentType != ApplicationJSON {
-		logging.Log.Errorf("got invalid request: %v", body)
-		w.WriteHeader(200)
-		w.Write([]byte("I don't speak this language."))
+	// The configuration stays the same for the whole request, even
+	// when it is reloaded meanwhile.
+	s := currentState()
+
+	// The deployments that are served on the path of the request.
+	routed := deployment.Route(s.conf.Deployments, req.URL.Path)
+	if len(routed) == 0 {
+		logging.Log.Warnf("No deployment is served on %v", req.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("A matching deployment was not found."))
+		return
+	}
+
+	// Reject requests from unknown networks before reading the body,
+	// the networks of the deployments can only narrow the global ones.
+	ip := network.ClientIP(req, s.trusted)
+	allowed, err := s.allowlist.Allows(ip)
+	if err != nil {
+		logging.Log.Errorf("refresh allowed networks: %v", err)
+	}
+	if !allowed {
+		rejectAddress(w, ip, "address is not allowed")
+		return
+	}
+	var reachable []deployment.Deployment
+	for _, d := range routed {
+		if deployment.AllowsAddress(d, ip) == nil {
+			reachable = append(reachable, d)
+		}
+	}
+	if len(reachable) == 0 {
+		rejectAddress(w, ip, "address is not allowed by the deployments")
+		return
+	}
+	routed = reachable
+
+	// The body of the request.
+	body, ok := readBody(w, req, s.conf.MaxBodySize)
+	if !ok {
 		return
 	}
 
 	// Construct the request struct.
-	request := request.Request{
+	r := request.Request{
 		Headers:  req.Header,
 		JSONBody: string(body),
 	}
 
-	if err = request.Parse(body); err != nil {
-		logging.Log.Errorf("cannot unmarshal string: %v", err)
+	// Detect the provider that sent the request.
+	provider := request.Detect(req.Header)
+
+	// Requests that no provider can parse may still be accepted by
+	// a deployment with a generic webhook.
+	parseErr := r.Parse(body)
+	if parseErr == request.ErrUnsupportedContentType {
+		logging.Log.Errorf("got request with unsupported content type: %v",
+			req.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte("I don't speak this language."))
+		return
+	}
+
+	// Check if request matches any deployment.
+	matches := deployment.FindMatching(routed, r)
+	if len(matches) == 0 && parseErr != nil {
+		logging.Log.Errorf("cannot parse %v request: %v", provider.Name(), parseErr)
 		w.WriteHeader(500)
 		w.Write([]byte("Error parsing request body to request struct."))
 		return
 	}
-
-	// Check if request matches a deployment.
-	match := deployment.FindMatching(conf.Deployments, request)
-	notFound := deployment.Deployment{}
-
-	if match == notFound {
+	if len(matches) == 0 {
 		logging.Log.Warnf("A deployment that matches the request cannot be found!")
 		w.WriteHeader(404)
 		w.Write([]byte("A matching deployment was not found."))
 		return
 	}
 
-	// Check the validity of the request and deployment.
-	if match.Secret != "" {
-		validSignature := request.HasValidSignature(match.Secret)
-		if !validSignature {
-			logging.Log.Errorf("Request integrity check failed, please verify the " +
-				"secret!")
-			w.WriteHeader(400)
-			w.Write([]byte("Invalid secret or signature."))
-			return
+	started, forbidden, replayed, skipped := 0, 0, 0, []string{}
+	recorded := false
+	for _, match := range matches {
+		// Parse the request with the generic webhook of the deployment.
+		mr := r
+		if match.Generic != nil {
+			if err = mr.ParseWith(*match.Generic, body); err != nil {
+				logging.Log.Errorf("cannot parse generic request: %v", err)
+				continue
+			}
+		}
+
+		// Check the validity of the request and deployment.
+		if match.HasSecret() || match.Generic != nil {
+			secret, ok := deployment.VerifySignature(match, mr)
+			if !ok {
+				logging.Log.Errorf("Request integrity check failed for %v, please "+
+					"verify the secret!", match.Repository)
+				continue
+			}
+			logging.Log.Infof("Request for %v is verified with %v", match.Repository, secret)
+		}
+
+		// Reject replays of a delivery once its signature is verified.
+		if reason := replayReason(s, mr, recorded); reason != "" {
+			logging.Log.Warnf("Rejecting request for %v: %v", match.Repository, reason)
+			logging.Audit("request rejected", log.Fields{
+				"repository": match.Repository,
+				"deployment": match.Name,
+				"delivery":   mr.Delivery(),
+				"reason":     reason,
+			})
+			replayed++
+			continue
+		}
+		recorded = true
+
+		// Check that the pusher and sender may trigger the deployment.
+		if err := deployment.Authorize(match, mr.Event); err != nil {
+			deployment.LogRejection(match, mr.Event, err)
+			forbidden++
+			continue
 		}
+
+		// Check for directives and paths that skip the deployment.
+		if reason := deployment.SkipReason(match, mr.Event); reason != "" {
+			deployment.LogSkip(match, mr.Event, reason)
+			skipped = append(skipped, reason)
+			continue
+		}
+
+		// Run the deployment.
+		started++
+		go func(d deployment.Deployment, event request.Event) {
+			if err := deployment.Deploy(d, event); err != nil {
+				logging.Log.Errorf("run deployment: %v", err)
+			}
+		}(match, mr.Event)
 	}
 
 	// Respond timely to the webook.
-	w.WriteHeader(200)
-	w.Write([]byte("Deployment has started."))
-
-	// Run the deployment.
-	var depErr error
-	go func(err error){
-		if err = deployment.Deploy(match); err != nil {
-			logging.Log.Errorf("run deployment: %v", err)
-		}
-	}(depErr)
+	switch {
+	case started > 0:
+		w.WriteHeader(200)
+		w.Write([]byte("Deployment has started."))
+	case len(skipped) > 0:
+		w.WriteHeader(200)
+		w.Write([]byte("Deployment has been skipped: " + strings.Join(skipped, "; ")))
+	case replayed > 0:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("Request has already been delivered or is too old."))
+	case forbidden > 0:
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("You are not allowed to trigger this deployment."))
+	default:
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid secret or signature."))
+	}
+}
+
+// rejectAddress responds to a request from an address that is not
+// allowed to trigger any deployment.
+func rejectAddress(w http.ResponseWriter, ip net.IP, reason string) {
+	logging.Log.Warnf("Rejecting request from %v: %v", ip, reason)
+	logging.Audit("request rejected", log.Fields{
+		"address": ip.String(),
+		"reason":  reason,
+	})
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte("You are not allowed to trigger this deployment."))
+}
+
+// replayReason returns why a request is a replay, or an empty string.
+// The delivery is only recorded once per request, by the first
+// deployment that verifies it.
+func replayReason(s *state, r request.Request, recorded bool) string {
+	if s.conf.Replay.Expired(r.Event.Timestamp) {
+		return fmt.Sprintf("event sent at %v is too old", r.Event.Timestamp)
+	}
+	id := r.Delivery()
+	if s.deliveries == nil || id == "" || recorded {
+		return ""
+	}
+	added, err := s.deliveries.Add(id)
+	if err != nil {
+		logging.Log.Errorf("persist replay protection: %v", err)
+	}
+	if !added {
+		return fmt.Sprintf("delivery %v was already seen", id)
+	}
+	return ""
+}
+
+// readBody reads the body of a request up to the maximum size, it
+// responds with an error and returns false when it cannot.
+func readBody(w http.ResponseWriter, req *http.Request, maxSize int64) ([]byte, bool) {
+	if req.ContentLength > maxSize {
+		logging.Log.Warnf("Rejecting request with a body of %d bytes", req.ContentLength)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("Request body is too large."))
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxSize+1))
+	if err != nil {
+		logging.Log.Errorf("cannot read request body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Cannot read request body."))
+		return nil, false
+	}
+	if int64(len(body)) > maxSize {
+		logging.Log.Warnf("Rejecting request with a body of more than %d bytes",
+			maxSize)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte("Request body is too large."))
+		return nil, false
+	}
+	return body, true
+}
+
+// check prints every problem of a configuration file and returns the
+// exit code of the check command.
+func check(configPath string) int {
+	var c config.Config
+	if err := config.Parse(&c, configPath); err != nil {
+		fmt.Printf("%v: %v\n", configPath, err)
+		return 1
+	}
+	errs := c.Check()
+	for _, err := range errs {
+		fmt.Printf("%v: %v\n", configPath, err)
+	}
+	if len(errs) > 0 {
+		fmt.Printf("%v: %d problems found\n", configPath, len(errs))
+		return 1
+	}
+	fmt.Printf("%v: configuration is valid\n", configPath)
+	return 0
 }
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klipitkas/hooktail/common"
+	config "github.com/klipitkas/hooktail/config"
+	deployment "github.com/klipitkas/hooktail/deployment"
+	"github.com/klipitkas/hooktail/network"
+	"github.com/klipitkas/hooktail/replay"
+)
+
+// errReader is a request body that cannot be read.
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestHandleRequest(t *testing.T) {
+
+	// A push whose head commit skips the deployment, so that accepted
+	// requests respond without running it.
+	push := `{"ref":"refs/heads/master","repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"},"sender":{"login":"klipitkas"},"commits":[{"id":"abc123","message":"Update docs [skip ci]"}]}`
+	form := url.Values{"payload": {push}}.Encode()
+
+	hooktail := deployment.Deployment{
+		Name:       "hooktail",
+		Repository: "git@github.com:klipitkas/hooktail.git",
+		Secret:     "secret",
+		Branch:     "master",
+		Path:       "/hooktail",
+	}
+
+	signed := func(body string) http.Header {
+		return http.Header{
+			"X-Github-Event":      {"push"},
+			"X-Github-Delivery":   {"72d3162e-cc78-11e3-81ab-4c9367dc0958"},
+			"X-Hub-Signature-256": {"sha256=" + common.Sha256Hmac(body, "secret")},
+		}
+	}
+	with := func(headers http.Header, key string, value string) http.Header {
+		headers.Set(key, value)
+		return headers
+	}
+
+	type args struct {
+		method  string
+		path    string
+		headers http.Header
+		body    io.Reader
+	}
+
+	tests := []struct {
+		name string
+		conf func(c *config.Config)
+		args args
+		// Whether the delivery was seen before.
+		delivered bool
+		want      int
+	}{
+		{
+			"Test a GET request is not allowed",
+			nil,
+			args{method: http.MethodGet, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusMethodNotAllowed,
+		},
+		{
+			"Test a path without a deployment is not found",
+			nil,
+			args{method: http.MethodPost, path: "/hooks/other", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusNotFound,
+		},
+		{
+			"Test a verified push is accepted",
+			nil,
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusOK,
+		},
+		{
+			"Test a verified push is accepted on the path of the deployment",
+			nil,
+			args{method: http.MethodPost, path: "/hooks/hooktail", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusOK,
+		},
+		{
+			"Test a verified form push is accepted",
+			nil,
+			args{
+				method:  http.MethodPost,
+				path:    "/",
+				headers: with(signed(form), "Content-Type", "application/x-www-form-urlencoded"),
+				body:    strings.NewReader(form),
+			},
+			false,
+			http.StatusOK,
+		},
+		{
+			"Test an unsupported content type",
+			nil,
+			args{
+				method:  http.MethodPost,
+				path:    "/",
+				headers: with(signed(push), "Content-Type", "text/plain"),
+				body:    strings.NewReader(push),
+			},
+			false,
+			http.StatusUnsupportedMediaType,
+		},
+		{
+			"Test a wrong signature",
+			nil,
+			args{
+				method:  http.MethodPost,
+				path:    "/",
+				headers: with(signed(push), "X-Hub-Signature-256", "sha256=0000"),
+				body:    strings.NewReader(push),
+			},
+			false,
+			http.StatusBadRequest,
+		},
+		{
+			"Test a body larger than the maximum by its content length",
+			func(c *config.Config) { c.MaxBodySize = 16 },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusRequestEntityTooLarge,
+		},
+		{
+			"Test a streamed body larger than the maximum",
+			func(c *config.Config) { c.MaxBodySize = 16 },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: ioutil.NopCloser(strings.NewReader(push))},
+			false,
+			http.StatusRequestEntityTooLarge,
+		},
+		{
+			"Test a body that cannot be read",
+			nil,
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: errReader{}},
+			false,
+			http.StatusBadRequest,
+		},
+		{
+			"Test an address outside of the allowed networks",
+			func(c *config.Config) { c.AllowedNetworks = []string{"10.0.0.0/8"} },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusForbidden,
+		},
+		{
+			"Test an address outside of the allowed networks of the deployment",
+			func(c *config.Config) { c.Deployments[0].AllowedNetworks = []string{"10.0.0.0/8"} },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusForbidden,
+		},
+		{
+			"Test an address inside of the allowed networks",
+			func(c *config.Config) { c.AllowedNetworks = []string{"192.0.2.0/24"} },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusOK,
+		},
+		{
+			"Test a sender that is not allowed",
+			func(c *config.Config) { c.Deployments[0].AllowedSenders = []string{"octocat"} },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusForbidden,
+		},
+		{
+			"Test a repeated delivery",
+			func(c *config.Config) { c.Replay = replay.Config{TTL: time.Hour} },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			true,
+			http.StatusConflict,
+		},
+		{
+			"Test a new delivery with replay protection",
+			func(c *config.Config) { c.Replay = replay.Config{TTL: time.Hour} },
+			args{method: http.MethodPost, path: "/", headers: signed(push), body: strings.NewReader(push)},
+			false,
+			http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config.Config{
+				MaxBodySize: config.DefaultMaxBodySize,
+				Deployments: []deployment.Deployment{hooktail},
+			}
+			if tt.conf != nil {
+				tt.conf(&c)
+			}
+			s := &state{conf: c}
+			networks, err := network.ParseNetworks(c.AllowedNetworks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.allowlist = network.Allowlist{Networks: networks}
+			if c.Replay.Enabled() {
+				if s.deliveries, err = replay.New(c.Replay); err != nil {
+					t.Fatal(err)
+				}
+				if tt.delivered {
+					s.deliveries.Add(tt.args.headers.Get("X-Github-Delivery"))
+				}
+			}
+			current.Store(s)
+
+			req := httptest.NewRequest(tt.args.method, tt.args.path, tt.args.body)
+			req.Header = tt.args.headers
+			w := httptest.NewRecorder()
+			handleRequest(w, req)
+			if w.Code != tt.want {
+				t.Errorf("got = %d (%s), want = %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
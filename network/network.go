@@ -0,0 +1,168 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseNetworks parses a list of CIDR ranges, single addresses are
+// treated as ranges that contain only that address.
+func ParseNetworks(list []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", s, err)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// Contains reports whether any of the networks contains the address.
+func Contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent a request. The
+// X-Forwarded-For and X-Real-IP headers are only used when the request
+// comes from one of the trusted proxies.
+func ClientIP(req *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if !Contains(trusted, peer) {
+		return peer
+	}
+
+	// Walk the forwarded addresses from the closest proxy to the client,
+	// the first untrusted address is the one that connected to them.
+	forwarded := []net.IP{}
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, s := range strings.Split(header, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+				forwarded = append(forwarded, ip)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !Contains(trusted, forwarded[i]) {
+			return forwarded[i]
+		}
+	}
+	if len(forwarded) > 0 {
+		return forwarded[0]
+	}
+	if ip := net.ParseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); ip != nil {
+		return ip
+	}
+	return peer
+}
+
+// Meta is a local copy of the GitHub meta API response, e.g. as saved
+// from https://api.github.com/meta. The file is read again when it is
+// modified, so it can be refreshed without a restart.
+type Meta struct {
+	// The path of the file.
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	hooks   []*net.IPNet
+}
+
+// NewMeta returns the GitHub meta ranges stored at a path.
+func NewMeta(path string) *Meta {
+	return &Meta{Path: path}
+}
+
+// Hooks returns the ranges that GitHub sends webhooks from. When the
+// file cannot be read again the previous ranges are returned along
+// with the error.
+func (m *Meta) Hooks() ([]*net.IPNet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info, err := os.Stat(m.Path)
+	if err != nil {
+		return m.hooks, fmt.Errorf("stat github meta file: %v", err)
+	}
+	if m.hooks != nil && info.ModTime().Equal(m.modTime) {
+		return m.hooks, nil
+	}
+
+	b, err := ioutil.ReadFile(m.Path)
+	if err != nil {
+		return m.hooks, fmt.Errorf("read github meta file: %v", err)
+	}
+	var meta struct {
+		Hooks []string `json:"hooks"`
+	}
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return m.hooks, fmt.Errorf("unmarshal github meta file: %v", err)
+	}
+	hooks, err := ParseNetworks(meta.Hooks)
+	if err != nil {
+		return m.hooks, fmt.Errorf("parse github meta file: %v", err)
+	}
+	m.hooks, m.modTime = hooks, info.ModTime()
+	return m.hooks, nil
+}
+
+// Allowlist is a list of networks that requests are accepted from.
+type Allowlist struct {
+	// The allowed networks.
+	Networks []*net.IPNet
+	// The GitHub hook ranges, which are also allowed.
+	Meta *Meta
+}
+
+// Empty reports whether the allowlist has no networks, which allows
+// every address.
+func (a Allowlist) Empty() bool {
+	return len(a.Networks) == 0 && a.Meta == nil
+}
+
+// Allows reports whether the address is allowed. An error is returned
+// when the GitHub hook ranges cannot be refreshed, the previous ranges
+// are still used.
+func (a Allowlist) Allows(ip net.IP) (bool, error) {
+	if a.Empty() || Contains(a.Networks, ip) {
+		return true, nil
+	}
+	if a.Meta == nil {
+		return false, nil
+	}
+	hooks, err := a.Meta.Hooks()
+	return Contains(hooks, ip), err
+}
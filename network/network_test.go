@@ -0,0 +1,147 @@
+package network_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klipitkas/hooktail/network"
+)
+
+func TestParseNetworks(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		list    []string
+		wantErr bool
+	}{
+		{"Parse CIDR ranges", []string{"192.30.252.0/22", "2a0a:a440::/29"}, false},
+		{"Parse single addresses", []string{"10.0.0.1", "::1"}, false},
+		{"Parse an invalid range should fail", []string{"10.0.0.0/33"}, true},
+		{"Parse an invalid address should fail", []string{"github"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := network.ParseNetworks(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && len(got) != len(tt.list) {
+				t.Errorf("got %d networks, want %d", len(got), len(tt.list))
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+
+	trusted, _ := network.ParseNetworks([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			"Test the peer address is used without proxies",
+			"192.30.252.1:4242",
+			nil,
+			"192.30.252.1",
+		},
+		{
+			"Test forwarded headers from untrusted peers are ignored",
+			"192.30.252.1:4242",
+			map[string]string{"X-Forwarded-For": "10.1.1.1", "X-Real-IP": "10.1.1.1"},
+			"192.30.252.1",
+		},
+		{
+			"Test the first untrusted forwarded address is used",
+			"10.0.0.1:4242",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 192.30.252.1, 10.0.0.2"},
+			"192.30.252.1",
+		},
+		{
+			"Test the real ip header of a trusted proxy",
+			"10.0.0.1:4242",
+			map[string]string{"X-Real-IP": "192.30.252.1"},
+			"192.30.252.1",
+		},
+		{
+			"Test the peer address is used without forwarded headers",
+			"10.0.0.1:4242",
+			nil,
+			"10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := network.ClientIP(req, trusted); got.String() != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hooktail")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "meta.json")
+	if err := ioutil.WriteFile(path, []byte(`{"hooks":["192.30.252.0/22"]}`), 0644); err != nil {
+		t.Fatalf("write meta file: %v", err)
+	}
+	networks, _ := network.ParseNetworks([]string{"10.0.0.0/8"})
+	allowlist := network.Allowlist{Networks: networks, Meta: network.NewMeta(path)}
+
+	tests := []struct {
+		name      string
+		allowlist network.Allowlist
+		ip        string
+		want      bool
+	}{
+		{"Test an empty allowlist allows everyone", network.Allowlist{}, "1.2.3.4", true},
+		{"Test an allowed network", allowlist, "10.1.2.3", true},
+		{"Test a github hook range", allowlist, "192.30.253.1", true},
+		{"Test an unknown address", allowlist, "1.2.3.4", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.allowlist.Allows(net.ParseIP(tt.ip))
+			if err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+
+	// The ranges are refreshed when the file changes.
+	if err := ioutil.WriteFile(path, []byte(`{"hooks":["1.2.3.0/24"]}`), 0644); err != nil {
+		t.Fatalf("write meta file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("change meta file times: %v", err)
+	}
+	if ok, _ := allowlist.Allows(net.ParseIP("1.2.3.4")); !ok {
+		t.Errorf("refreshed github hook range is not allowed")
+	}
+}
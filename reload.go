@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	config "github.com/klipitkas/hooktail/config"
+	"github.com/klipitkas/hooktail/logging"
+	"github.com/klipitkas/hooktail/network"
+	"github.com/klipitkas/hooktail/replay"
+)
+
+// state is everything that requests are handled with, it is replaced
+// as a whole when the configuration is reloaded so that every request
+// sees a consistent configuration.
+type state struct {
+	conf config.Config
+	// The networks that requests are accepted from.
+	allowlist network.Allowlist
+	// The proxies that forward requests to the server.
+	trusted []*net.IPNet
+	// The recently seen deliveries, nil without replay protection.
+	deliveries *replay.Cache
+}
+
+var (
+	// The state of the server, a *state.
+	current atomic.Value
+	// Serializes the reloads of the configuration.
+	reloadMu sync.Mutex
+)
+
+// currentState returns the state that requests are handled with.
+func currentState() *state {
+	return current.Load().(*state)
+}
+
+// load parses and checks a configuration file and returns its state,
+// or every problem found. The replay protection of the previous state
+// is kept when its options did not change.
+func load(configPath string, previous *state) (*state, []error) {
+	var c config.Config
+	if err := config.Parse(&c, configPath); err != nil {
+		return nil, []error{fmt.Errorf("parse configuration: %v", err)}
+	}
+	if errs := c.Check(); len(errs) > 0 {
+		return nil, errs
+	}
+
+	s := &state{conf: c}
+	networks, err := network.ParseNetworks(c.AllowedNetworks)
+	if err != nil {
+		return nil, []error{fmt.Errorf("parse allowed networks: %v", err)}
+	}
+	s.allowlist = network.Allowlist{Networks: networks}
+	if c.GitHubMetaFile != "" {
+		s.allowlist.Meta = network.NewMeta(c.GitHubMetaFile)
+	}
+	if s.trusted, err = network.ParseNetworks(c.TrustedProxies); err != nil {
+		return nil, []error{fmt.Errorf("parse trusted proxies: %v", err)}
+	}
+
+	if !c.Replay.Enabled() {
+		return s, nil
+	}
+	if previous != nil && previous.deliveries != nil && sameCache(previous.conf.Replay, c.Replay) {
+		s.deliveries = previous.deliveries
+		return s, nil
+	}
+	if s.deliveries, err = replay.New(c.Replay); err != nil {
+		return nil, []error{fmt.Errorf("load replay protection: %v", err)}
+	}
+	return s, nil
+}
+
+// sameCache reports whether two replay configurations use the same
+// cache of delivery IDs.
+func sameCache(a, b replay.Config) bool {
+	return a.TTL == b.TTL && a.Size == b.Size && a.File == b.File
+}
+
+// reload loads the configuration file again and swaps it in. The
+// running configuration is kept when the new one has any problem, and
+// running deployments are not interrupted either way.
+func reload(configPath string) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	previous := currentState()
+	s, errs := load(configPath, previous)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			logging.Log.Errorf("reload configuration: %v", err)
+		}
+		logging.Log.Errorf("Keeping the running configuration, %v has %d problems",
+			configPath, len(errs))
+		return
+	}
+
+	old, next := previous.conf, s.conf
+	if old.Port != next.Port || old.ReadTimeout != next.ReadTimeout ||
+		old.ReadHeaderTimeout != next.ReadHeaderTimeout ||
+		old.WriteTimeout != next.WriteTimeout || old.IdleTimeout != next.IdleTimeout ||
+		old.WatchInterval != next.WatchInterval {
+		logging.Log.Warnf("The port, server timeouts and watch interval " +
+			"only change after a restart")
+	}
+
+	current.Store(s)
+	logging.Log.Printf("Reloaded configuration %v with %d deployments",
+		configPath, len(next.Deployments))
+}
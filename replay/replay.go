@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultSize is the number of delivery IDs that are remembered when
+// no size is configured.
+const DefaultSize = 10000
+
+// Config is the configuration of the replay protection.
+type Config struct {
+	// How long delivery IDs are remembered, replay protection is
+	// disabled when it is not set.
+	TTL time.Duration `yaml:"ttl,omitempty" json:"ttl,omitempty"`
+	// The maximum number of remembered delivery IDs, the oldest are
+	// forgotten first. Defaults to DefaultSize.
+	Size int `yaml:"size,omitempty" json:"size,omitempty"`
+	// The file the delivery IDs are persisted to across restarts.
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// The maximum age of events whose provider sends a timestamp.
+	MaxAge time.Duration `yaml:"max_age,omitempty" json:"max_age,omitempty"`
+}
+
+// Enabled reports whether delivery IDs are remembered.
+func (c Config) Enabled() bool {
+	return c.TTL > 0
+}
+
+// Expired reports whether an event sent at the given time is older
+// than the maximum age. Events without a timestamp never expire.
+func (c Config) Expired(sent time.Time) bool {
+	return c.MaxAge > 0 && !sent.IsZero() && time.Since(sent) > c.MaxAge
+}
+
+// Cache is a bounded set of recently seen delivery IDs.
+type Cache struct {
+	config Config
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// New returns a cache with the delivery IDs of the configured file,
+// when it exists.
+func New(config Config) (*Cache, error) {
+	if config.Size <= 0 {
+		config.Size = DefaultSize
+	}
+	c := &Cache{config: config, seen: map[string]time.Time{}}
+	if config.File == "" {
+		return c, nil
+	}
+	b, err := ioutil.ReadFile(config.File)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read replay file %v: %v", config.File, err)
+	}
+	if err := json.Unmarshal(b, &c.seen); err != nil {
+		return nil, fmt.Errorf("unmarshal replay file %v: %v", config.File, err)
+	}
+	c.prune(time.Now())
+	return c, nil
+}
+
+// Add records a delivery ID, it returns false when the ID was already
+// seen within the TTL. The error reports a failure to persist the IDs,
+// the ID is still recorded in memory.
+func (c *Cache) Add(id string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.prune(now)
+	if _, ok := c.seen[id]; ok {
+		return false, nil
+	}
+	c.seen[id] = now
+	for len(c.seen) > c.config.Size {
+		c.evictOldest()
+	}
+	return true, c.save()
+}
+
+// Len returns the number of remembered delivery IDs.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.seen)
+}
+
+// prune forgets the delivery IDs that are older than the TTL.
+func (c *Cache) prune(now time.Time) {
+	for id, seen := range c.seen {
+		if now.Sub(seen) > c.config.TTL {
+			delete(c.seen, id)
+		}
+	}
+}
+
+// evictOldest forgets the delivery ID that was seen first.
+func (c *Cache) evictOldest() {
+	oldest, first := "", time.Time{}
+	for id, seen := range c.seen {
+		if oldest == "" || seen.Before(first) {
+			oldest, first = id, seen
+		}
+	}
+	delete(c.seen, oldest)
+}
+
+// save writes the delivery IDs to the configured file, through a
+// temporary file so that a crash never leaves it half written.
+func (c *Cache) save() error {
+	if c.config.File == "" {
+		return nil
+	}
+	b, err := json.Marshal(c.seen)
+	if err != nil {
+		return fmt.Errorf("marshal replay file: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.config.File), ".replay-")
+	if err != nil {
+		return fmt.Errorf("create replay file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write replay file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write replay file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.config.File); err != nil {
+		return fmt.Errorf("rename replay file: %v", err)
+	}
+	return nil
+}
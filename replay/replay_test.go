@@ -0,0 +1,97 @@
+package replay_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klipitkas/hooktail/replay"
+)
+
+func TestCacheAdd(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "hooktail")
+	if err != nil {
+		t.Fatalf("create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := replay.Config{TTL: time.Hour, Size: 2, File: filepath.Join(dir, "replay.json")}
+	cache, err := replay.New(config)
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"Test a new delivery is accepted", "a", true},
+		{"Test a duplicate delivery is rejected", "a", false},
+		{"Test another delivery is accepted", "b", true},
+		{"Test the cache forgets the oldest delivery", "c", true},
+		{"Test a recent duplicate is still rejected", "c", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cache.Add(tt.id)
+			if err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+	if cache.Len() != 2 {
+		t.Errorf("got %d deliveries, want 2", cache.Len())
+	}
+
+	// The deliveries persist across restarts.
+	restarted, err := replay.New(config)
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+	if ok, _ := restarted.Add("c"); ok {
+		t.Errorf("persisted delivery is accepted after a restart")
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cache, err := replay.New(replay.Config{TTL: time.Millisecond})
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+	cache.Add("a")
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := cache.Add("a"); !ok {
+		t.Errorf("expired delivery is rejected")
+	}
+}
+
+func TestConfigExpired(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		config replay.Config
+		sent   time.Time
+		want   bool
+	}{
+		{"Test events never expire without a maximum age", replay.Config{}, time.Now().Add(-time.Hour), false},
+		{"Test events without a timestamp never expire", replay.Config{MaxAge: time.Minute}, time.Time{}, false},
+		{"Test a recent event", replay.Config{MaxAge: time.Minute}, time.Now(), false},
+		{"Test an old event", replay.Config{MaxAge: time.Minute}, time.Now().Add(-time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.Expired(tt.sent); got != tt.want {
+				t.Errorf("got = %v, want = %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package request
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/klipitkas/hooktail/common"
+)
+
+// The X-Event-Key values of the Bitbucket push events.
+const (
+	bitbucketCloudPush  = "repo:push"
+	bitbucketServerPush = "repo:refs_changed"
+)
+
+// bitbucketServerDate is the layout of the date of Bitbucket Server
+// payloads, e.g. "2017-09-19T09:58:11+1000".
+const bitbucketServerDate = "2006-01-02T15:04:05-0700"
+
+// bitbucketCloudPayload is the payload of the Bitbucket Cloud push event.
+type bitbucketCloudPayload struct {
+	Push struct {
+		Changes []struct {
+			New     *bitbucketCloudRef `json:"new"`
+			Old     *bitbucketCloudRef `json:"old"`
+			Commits []struct {
+				Hash    string `json:"hash"`
+				Message string `json:"message"`
+				Author  struct {
+					User struct {
+						DisplayName string `json:"display_name"`
+						Nickname    string `json:"nickname"`
+					} `json:"user"`
+				} `json:"author"`
+			} `json:"commits"`
+		} `json:"changes"`
+	} `json:"push"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		Links    struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	} `json:"repository"`
+	Actor struct {
+		DisplayName string `json:"display_name"`
+		Nickname    string `json:"nickname"`
+	} `json:"actor"`
+}
+
+// bitbucketCloudRef is a branch or tag of a Bitbucket Cloud push.
+type bitbucketCloudRef struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+// bitbucketServerPayload is the payload of the Bitbucket Server (and
+// Data Center) refs changed event.
+type bitbucketServerPayload struct {
+	Date  string `json:"date"`
+	Actor struct {
+		Name         string `json:"name"`
+		EmailAddress string `json:"emailAddress"`
+		DisplayName  string `json:"displayName"`
+	} `json:"actor"`
+	Repository struct {
+		Slug    string `json:"slug"`
+		Project struct {
+			Key string `json:"key"`
+		} `json:"project"`
+		Links struct {
+			Clone []struct {
+				Href string `json:"href"`
+				Name string `json:"name"`
+			} `json:"clone"`
+		} `json:"links"`
+	} `json:"repository"`
+	Changes []struct {
+		Ref struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		} `json:"ref"`
+		FromHash string `json:"fromHash"`
+		ToHash   string `json:"toHash"`
+		Type     string `json:"type"`
+	} `json:"changes"`
+}
+
+// Bitbucket is the provider of the Bitbucket Cloud and Bitbucket Server
+// webhooks, the flavor is detected from the X-Event-Key header.
+type Bitbucket struct{}
+
+// Name returns the name of the provider.
+func (Bitbucket) Name() string {
+	return ProviderBitbucket
+}
+
+// Detect reports whether the headers belong to a Bitbucket request.
+func (Bitbucket) Detect(headers http.Header) bool {
+	return headers.Get("X-Event-Key") != ""
+}
+
+// Verify checks the X-Hub-Signature header, a hex encoded HMAC-SHA256
+// of the body with a "sha256=" prefix.
+func (Bitbucket) Verify(headers http.Header, body []byte, secret string) bool {
+	signature := headers.Get("X-Hub-Signature")
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	signature = strings.TrimPrefix(signature, "sha256=")
+	return hmac.Equal([]byte(signature), []byte(common.Sha256Hmac(string(body), secret)))
+}
+
+// Delivery returns the X-Request-UUID of Bitbucket Cloud or the
+// X-Request-Id of Bitbucket Server.
+func (Bitbucket) Delivery(headers http.Header) string {
+	if id := headers.Get("X-Request-UUID"); id != "" {
+		return id
+	}
+	return headers.Get("X-Request-Id")
+}
+
+// Parse parses a Bitbucket Cloud or Server push payload to an event.
+func (Bitbucket) Parse(headers http.Header, body []byte) (Event, error) {
+	if !isJSON(headers) {
+		return Event{}, ErrUnsupportedContentType
+	}
+	switch key := headers.Get("X-Event-Key"); key {
+	case bitbucketCloudPush:
+		return parseBitbucketCloud(body)
+	case bitbucketServerPush:
+		return parseBitbucketServer(body)
+	default:
+		return Event{}, fmt.Errorf("unsupported bitbucket event %q", key)
+	}
+}
+
+// parseBitbucketCloud parses a Bitbucket Cloud push payload to an event
+// using the first change that creates or updates a branch or tag.
+func parseBitbucketCloud(body []byte) (Event, error) {
+	var p bitbucketCloudPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal bitbucket body to json: %v", err)
+	}
+	event := Event{
+		Provider: ProviderBitbucket,
+		Repository: Repository{
+			FullName: p.Repository.FullName,
+			SSHURL:   bitbucketCloudSSHURL(p.Repository.Links.HTML.Href, p.Repository.FullName),
+			CloneURL: bitbucketCloudCloneURL(p.Repository.Links.HTML.Href),
+		},
+		Pusher: User{
+			Name:     p.Actor.DisplayName,
+			Username: p.Actor.Nickname,
+		},
+	}
+	for _, c := range p.Push.Changes {
+		if c.New == nil {
+			continue
+		}
+		event.Type = "push"
+		event.Ref = "refs/heads/" + c.New.Name
+		if c.New.Type == "tag" {
+			event.Type = "tag_push"
+			event.Ref = "refs/tags/" + c.New.Name
+		}
+		event.After = c.New.Target.Hash
+		if c.Old != nil {
+			event.Before = c.Old.Target.Hash
+		}
+		for _, commit := range c.Commits {
+			event.Commits = append(event.Commits, Commit{
+				ID:      commit.Hash,
+				Message: commit.Message,
+				Author: User{
+					Name:     commit.Author.User.DisplayName,
+					Username: commit.Author.User.Nickname,
+				},
+			})
+		}
+		event.Sender = event.Pusher
+		return event, nil
+	}
+	return Event{}, fmt.Errorf("bitbucket push without any new branch or tag")
+}
+
+// parseBitbucketServer parses a Bitbucket Server push payload to an event
+// using the first change that is not a deletion.
+func parseBitbucketServer(body []byte) (Event, error) {
+	var p bitbucketServerPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal bitbucket body to json: %v", err)
+	}
+	event := Event{
+		Provider: ProviderBitbucket,
+		Pusher: User{
+			Name:     p.Actor.DisplayName,
+			Username: p.Actor.Name,
+			Email:    p.Actor.EmailAddress,
+		},
+	}
+	if t, err := time.Parse(bitbucketServerDate, p.Date); err == nil {
+		event.Timestamp = t
+	}
+	if p.Repository.Project.Key != "" && p.Repository.Slug != "" {
+		event.Repository.FullName = p.Repository.Project.Key + "/" + p.Repository.Slug
+	}
+	for _, l := range p.Repository.Links.Clone {
+		switch l.Name {
+		case "ssh":
+			event.Repository.SSHURL = l.Href
+		case "http":
+			event.Repository.CloneURL = l.Href
+		}
+	}
+	for _, c := range p.Changes {
+		if c.Type == "DELETE" {
+			continue
+		}
+		event.Type = "push"
+		if c.Ref.Type == "TAG" {
+			event.Type = "tag_push"
+		}
+		event.Ref = c.Ref.ID
+		event.Before = c.FromHash
+		event.After = c.ToHash
+		event.Sender = event.Pusher
+		return event, nil
+	}
+	return Event{}, fmt.Errorf("bitbucket push without any new branch or tag")
+}
+
+// bitbucketCloudSSHURL returns the SSH URL of a Bitbucket Cloud repository,
+// which is not part of the payload, from its web URL and full name.
+func bitbucketCloudSSHURL(htmlURL string, fullName string) string {
+	u, err := url.Parse(htmlURL)
+	if err != nil || u.Host == "" || fullName == "" {
+		return ""
+	}
+	return "git@" + u.Host + ":" + fullName + ".git"
+}
+
+// bitbucketCloudCloneURL returns the HTTPS clone URL of a Bitbucket Cloud
+// repository from its web URL.
+func bitbucketCloudCloneURL(htmlURL string) string {
+	if htmlURL == "" {
+		return ""
+	}
+	return htmlURL + ".git"
+}
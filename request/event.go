@@ -0,0 +1,154 @@
+package request
+
+import (
+	"strings"
+	"time"
+)
+
+// The supported webhook providers.
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderGitea     = "gitea"
+	ProviderBitbucket = "bitbucket"
+)
+
+// The event types that are handled specially.
+const (
+	EventPush        = "push"
+	EventWorkflowRun = "workflow_run"
+	EventCheckSuite  = "check_suite"
+	EventPullRequest = "pull_request"
+)
+
+// Event is the provider independent representation of a webhook
+// request, it contains what is needed to match and run a deployment.
+type Event struct {
+	// The provider that sent the webhook, e.g. "github".
+	Provider string
+	// The type of the event, e.g. "push" or "tag_push".
+	Type string
+	// The action of the event, e.g. "completed".
+	Action string
+	// The repository of the event.
+	Repository Repository
+	// The full git reference, e.g. "refs/heads/master".
+	Ref string
+	// The commit before the push.
+	Before string
+	// The commit after the push.
+	After string
+	// The user that pushed the commits.
+	Pusher User
+	// The user that triggered the event, e.g. the "sender" of GitHub.
+	Sender User
+	// The pushed commits, when the provider sends them.
+	Commits []Commit
+	// Whether the provider left out some of the pushed commits.
+	Truncated bool
+	// The name of the workflow, or the app of a check suite.
+	Workflow string
+	// The conclusion of a workflow run or check suite, e.g. "success".
+	Conclusion string
+	// The pull request of a pull_request event.
+	PullRequest PullRequest
+	// The time the provider sent the event, when it is part of the
+	// payload.
+	Timestamp time.Time
+}
+
+// PullRequest is the pull request of an event.
+type PullRequest struct {
+	Number int
+	Title  string
+	Author string
+	Merged bool
+	Labels []string
+}
+
+// Branch returns the branch name of the reference, or an empty string
+// when the reference is not a branch.
+func (e Event) Branch() string {
+	if !strings.HasPrefix(e.Ref, "refs/heads/") {
+		return ""
+	}
+	return strings.TrimPrefix(e.Ref, "refs/heads/")
+}
+
+// Repository identifies the repository of an event.
+type Repository struct {
+	// The full name, e.g. "klipitkas/hooktail".
+	FullName string
+	// The SSH URL, e.g. "git@github.com:klipitkas/hooktail.git".
+	SSHURL string
+	// The HTTP(S) clone URL.
+	CloneURL string
+}
+
+// Matches reports whether a configured repository URL identifies
+// the repository, either by its SSH or clone URL.
+func (r Repository) Matches(url string) bool {
+	return url != "" && (url == r.SSHURL || url == r.CloneURL)
+}
+
+// User is a user that triggered an event.
+type User struct {
+	Name     string
+	Username string
+	Email    string
+}
+
+// Commit is a commit that is part of an event.
+type Commit struct {
+	ID       string
+	Message  string
+	Author   User
+	Added    []string
+	Modified []string
+	Removed  []string
+}
+
+// hasFiles reports whether the provider sent the changed files of the
+// commit, an empty list is still a list.
+func (c Commit) hasFiles() bool {
+	return c.Added != nil || c.Modified != nil || c.Removed != nil
+}
+
+// Files returns the files that the commits of the event changed. The
+// boolean is false when the list is incomplete, because the provider
+// does not send the files or truncated the commits.
+func (e Event) Files() ([]string, bool) {
+	if len(e.Commits) == 0 || e.Truncated {
+		return nil, false
+	}
+	seen := map[string]bool{}
+	files := []string{}
+	for _, c := range e.Commits {
+		if !c.hasFiles() {
+			return nil, false
+		}
+		for _, list := range [][]string{c.Added, c.Modified, c.Removed} {
+			for _, f := range list {
+				if !seen[f] {
+					seen[f] = true
+					files = append(files, f)
+				}
+			}
+		}
+	}
+	return files, true
+}
+
+// Head returns the head commit of the event, which is the commit of the
+// After SHA or else the last of the commits.
+func (e Event) Head() Commit {
+	for _, c := range e.Commits {
+		if c.ID == e.After {
+			return c
+		}
+	}
+	if len(e.Commits) == 0 {
+		return Commit{}
+	}
+	return e.Commits[len(e.Commits)-1]
+}
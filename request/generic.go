@@ -0,0 +1,264 @@
+package request
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klipitkas/hooktail/common"
+)
+
+// ProviderGeneric is the name of the generic JSON webhook provider.
+const ProviderGeneric = "generic"
+
+// ErrConditionsNotMet is returned by the generic provider when the
+// payload does not satisfy the match conditions.
+var ErrConditionsNotMet = errors.New("match conditions not met")
+
+// Generic is the provider of the webhooks of CI systems and custom
+// tools. It is configured per deployment with expressions, e.g.
+// "repository.ssh_url" or "$.commits[0].id", that extract the fields
+// of the event from a JSON payload.
+type Generic struct {
+	// The expression of the repository URL.
+	Repository string `yaml:"repository,omitempty" json:"repository,omitempty"`
+	// The expression of the full git reference, e.g. "refs/heads/master",
+	// which must be the branch or the tag of the deployment.
+	Ref string `yaml:"ref,omitempty" json:"ref,omitempty"`
+	// The expression of the commit SHA, which is deployed instead of the
+	// head of the branch.
+	SHA string `yaml:"sha,omitempty" json:"sha,omitempty"`
+	// The expression of the time the event was sent, either RFC 3339 or
+	// seconds since the epoch.
+	Timestamp string `yaml:"timestamp,omitempty" json:"timestamp,omitempty"`
+	// The header of the unique ID of each delivery, used to reject replays.
+	DeliveryHeader string `yaml:"delivery_header,omitempty" json:"delivery_header,omitempty"`
+	// The expressions of payload fields that must have the given value.
+	Match map[string]string `yaml:"match,omitempty" json:"match,omitempty"`
+	// The header of the HMAC signature, defaults to X-Hub-Signature-256.
+	SignatureHeader string `yaml:"signature_header,omitempty" json:"signature_header,omitempty"`
+	// The HMAC algorithm, one of sha1, sha256 (default) or sha512.
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	// The prefix of the signature, defaults to "<algorithm>=" when the
+	// default header is used.
+	SignaturePrefix string `yaml:"signature_prefix,omitempty" json:"signature_prefix,omitempty"`
+	// A static token that is expected as "Authorization: Bearer <token>".
+	Token string `yaml:"token,omitempty" json:"token,omitempty"`
+	// The environment variable that holds the token.
+	TokenEnv string `yaml:"token_env,omitempty" json:"token_env,omitempty"`
+	// The file that holds the token.
+	TokenFile string `yaml:"token_file,omitempty" json:"token_file,omitempty"`
+}
+
+// Name returns the name of the provider.
+func (Generic) Name() string {
+	return ProviderGeneric
+}
+
+// Detect always returns false, generic webhooks cannot be detected
+// from their headers and are only used through the deployments that
+// configure them.
+func (Generic) Detect(headers http.Header) bool {
+	return false
+}
+
+// Verify checks the bearer token when one is configured and the HMAC
+// signature of the body when a secret is given.
+func (g Generic) Verify(headers http.Header, body []byte, secret string) bool {
+	if g.Token != "" {
+		token := strings.TrimPrefix(headers.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(g.Token)) != 1 {
+			return false
+		}
+	}
+	if secret == "" {
+		return true
+	}
+	header, prefix := g.SignatureHeader, g.SignaturePrefix
+	if header == "" {
+		header, prefix = "X-Hub-Signature-256", g.algorithm()+"="
+	}
+	signature := headers.Get(header)
+	if signature == "" || !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	expected, err := hmacHex(g.algorithm(), string(body), secret)
+	if err != nil {
+		return false
+	}
+	signature = strings.TrimPrefix(signature, prefix)
+	return hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected))
+}
+
+// Delivery returns the ID of the delivery from the configured header.
+func (g Generic) Delivery(headers http.Header) string {
+	if g.DeliveryHeader == "" {
+		return ""
+	}
+	return headers.Get(g.DeliveryHeader)
+}
+
+// Parse extracts the event from a JSON payload and checks the match
+// conditions, it returns ErrConditionsNotMet when they do not hold.
+func (g Generic) Parse(headers http.Header, body []byte) (Event, error) {
+	if !isJSON(headers) {
+		return Event{}, ErrUnsupportedContentType
+	}
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return Event{}, fmt.Errorf("unmarshal generic body to json: %v", err)
+	}
+	for expr, want := range g.Match {
+		got, err := Lookup(payload, expr)
+		if err != nil || got != want {
+			return Event{}, ErrConditionsNotMet
+		}
+	}
+	event := Event{Provider: ProviderGeneric, Type: ProviderGeneric}
+	fields := []struct {
+		expr  string
+		value *string
+	}{
+		{g.Repository, &event.Repository.SSHURL},
+		{g.Ref, &event.Ref},
+		{g.SHA, &event.After},
+	}
+	for _, f := range fields {
+		if f.expr == "" {
+			continue
+		}
+		value, err := Lookup(payload, f.expr)
+		if err != nil {
+			return Event{}, fmt.Errorf("extract %q: %v", f.expr, err)
+		}
+		*f.value = value
+	}
+	event.Repository.CloneURL = event.Repository.SSHURL
+	if g.Timestamp != "" {
+		value, err := Lookup(payload, g.Timestamp)
+		if err != nil {
+			return Event{}, fmt.Errorf("extract %q: %v", g.Timestamp, err)
+		}
+		if event.Timestamp, err = parseTimestamp(value); err != nil {
+			return Event{}, fmt.Errorf("parse timestamp %q: %v", value, err)
+		}
+	}
+	return event, nil
+}
+
+// Validate checks the configuration of the generic provider.
+func (g Generic) Validate() error {
+	if _, err := hmacHex(g.algorithm(), "", ""); err != nil {
+		return err
+	}
+	// Without either, any JSON payload would match the deployment.
+	if g.Repository == "" && len(g.Match) == 0 {
+		return errors.New("a repository expression or a match condition is required")
+	}
+	exprs := []string{g.Repository, g.Ref, g.SHA, g.Timestamp}
+	for expr := range g.Match {
+		exprs = append(exprs, expr)
+	}
+	for _, expr := range exprs {
+		if expr == "" {
+			continue
+		}
+		if _, err := splitPath(expr); err != nil {
+			return fmt.Errorf("invalid expression %q: %v", expr, err)
+		}
+	}
+	return nil
+}
+
+// parseTimestamp parses an RFC 3339 time or seconds since the epoch.
+func parseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// algorithm returns the configured HMAC algorithm or the default.
+func (g Generic) algorithm() string {
+	if g.Algorithm == "" {
+		return "sha256"
+	}
+	return strings.ToLower(g.Algorithm)
+}
+
+// hmacHex returns the hex encoded HMAC of a message.
+func hmacHex(algorithm string, message string, secret string) (string, error) {
+	switch algorithm {
+	case "sha1":
+		return common.Sha1Hmac(message, secret), nil
+	case "sha256":
+		return common.Sha256Hmac(message, secret), nil
+	case "sha512":
+		return common.Sha512Hmac(message, secret), nil
+	}
+	return "", fmt.Errorf("unsupported hmac algorithm %q", algorithm)
+}
+
+// Lookup returns the value of a decoded JSON payload at a path such
+// as "repository.name", "commits.0.id", "commits[0].id" or the JSONPath
+// form "$.commits[0].id". Values that are not strings are formatted
+// the way they appear in JSON.
+func Lookup(payload interface{}, path string) (string, error) {
+	keys, err := splitPath(path)
+	if err != nil {
+		return "", err
+	}
+	value := payload
+	for _, key := range keys {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return "", fmt.Errorf("field %q not found", key)
+			}
+			value = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("invalid index %q", key)
+			}
+			value = v[i]
+		default:
+			return "", fmt.Errorf("field %q not found", key)
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// splitPath splits a lookup path into its keys and indexes.
+func splitPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	if path == "" {
+		return nil, errors.New("empty path")
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("empty key in path %q", path)
+		}
+	}
+	return keys, nil
+}
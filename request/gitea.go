@@ -0,0 +1,139 @@
+package request
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/klipitkas/hooktail/common"
+)
+
+// giteaPush is the payload of the Gitea, Forgejo and Gogs push event.
+type giteaPush struct {
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		SSHURL   string `json:"ssh_url"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	Pusher  giteaUser `json:"pusher"`
+	Sender  giteaUser `json:"sender"`
+	Commits []struct {
+		ID       string    `json:"id"`
+		Message  string    `json:"message"`
+		Author   giteaUser `json:"author"`
+		Added    []string  `json:"added"`
+		Modified []string  `json:"modified"`
+		Removed  []string  `json:"removed"`
+	} `json:"commits"`
+	TotalCommits int `json:"total_commits"`
+}
+
+// giteaUser is a user of a Gitea or Gogs payload.
+type giteaUser struct {
+	Login    string `json:"login"`
+	Username string `json:"username"`
+	FullName string `json:"full_name"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+}
+
+// user returns the normalized user, Gogs only sets the username and
+// commit authors only have a name.
+func (u giteaUser) user() User {
+	user := User{Name: u.FullName, Username: u.Login, Email: u.Email}
+	if user.Username == "" {
+		user.Username = u.Username
+	}
+	if user.Name == "" {
+		user.Name = u.Name
+	}
+	return user
+}
+
+// Gitea is the provider of the Gitea, Forgejo and Gogs webhooks.
+type Gitea struct{}
+
+// Name returns the name of the provider.
+func (Gitea) Name() string {
+	return ProviderGitea
+}
+
+// Detect reports whether the headers belong to a Gitea or Gogs request.
+// Gitea also sends the GitHub headers, so it has to be detected first.
+func (Gitea) Detect(headers http.Header) bool {
+	return giteaEvent(headers) != ""
+}
+
+// Verify checks the X-Gitea-Signature or X-Gogs-Signature header, a hex
+// encoded HMAC-SHA256 of the body without any prefix.
+func (Gitea) Verify(headers http.Header, body []byte, secret string) bool {
+	signature := headers.Get("X-Gitea-Signature")
+	if signature == "" {
+		signature = headers.Get("X-Gogs-Signature")
+	}
+	return signature != "" &&
+		hmac.Equal([]byte(signature), []byte(common.Sha256Hmac(string(body), secret)))
+}
+
+// Delivery returns the X-Gitea-Delivery or X-Gogs-Delivery ID of the
+// request.
+func (Gitea) Delivery(headers http.Header) string {
+	if id := headers.Get("X-Gitea-Delivery"); id != "" {
+		return id
+	}
+	return headers.Get("X-Gogs-Delivery")
+}
+
+// Parse parses a Gitea or Gogs push payload to an event.
+func (Gitea) Parse(headers http.Header, body []byte) (Event, error) {
+	if !isJSON(headers) {
+		return Event{}, ErrUnsupportedContentType
+	}
+	eventType := giteaEvent(headers)
+	if eventType != "push" {
+		return Event{}, fmt.Errorf("unsupported gitea event %q", eventType)
+	}
+	var p giteaPush
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal gitea body to json: %v", err)
+	}
+	event := Event{
+		Provider: ProviderGitea,
+		Type:     eventType,
+		Repository: Repository{
+			FullName: p.Repository.FullName,
+			SSHURL:   p.Repository.SSHURL,
+			CloneURL: p.Repository.CloneURL,
+		},
+		Ref:    p.Ref,
+		Before: p.Before,
+		After:  p.After,
+		Pusher: p.Pusher.user(),
+		Sender: p.Sender.user(),
+	}
+	for _, c := range p.Commits {
+		event.Commits = append(event.Commits, Commit{
+			ID:       c.ID,
+			Message:  c.Message,
+			Author:   c.Author.user(),
+			Added:    c.Added,
+			Modified: c.Modified,
+			Removed:  c.Removed,
+		})
+	}
+	// Gitea only sends a limited number of commits of a push.
+	event.Truncated = p.TotalCommits > len(p.Commits)
+	return event, nil
+}
+
+// giteaEvent returns the event type header of a Gitea or Gogs request.
+func giteaEvent(headers http.Header) string {
+	if event := headers.Get("X-Gitea-Event"); event != "" {
+		return event
+	}
+	return headers.Get("X-Gogs-Event")
+}
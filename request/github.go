@@ -0,0 +1,305 @@
+package request
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/klipitkas/hooktail/common"
+)
+
+// githubPush is the payload of the GitHub push event.
+type githubPush struct {
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		SSHURL   string `json:"ssh_url"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	Pusher struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"pusher"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Commits []githubCommit `json:"commits"`
+}
+
+// githubCheckRun is the payload of the GitHub workflow_run and
+// check_suite events, only one of the runs is set.
+type githubCheckRun struct {
+	Action      string       `json:"action"`
+	WorkflowRun *githubRun   `json:"workflow_run"`
+	CheckSuite  *githubSuite `json:"check_suite"`
+	Repository  struct {
+		FullName string `json:"full_name"`
+		SSHURL   string `json:"ssh_url"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// githubRun is the workflow run of a workflow_run event.
+type githubRun struct {
+	Name           string `json:"name"`
+	Event          string `json:"event"`
+	HeadBranch     string `json:"head_branch"`
+	HeadSHA        string `json:"head_sha"`
+	Conclusion     string `json:"conclusion"`
+	HeadRepository struct {
+		FullName string `json:"full_name"`
+	} `json:"head_repository"`
+}
+
+// githubSuite is the check suite of a check_suite event.
+type githubSuite struct {
+	HeadBranch   *string `json:"head_branch"`
+	HeadSHA      string  `json:"head_sha"`
+	Conclusion   string  `json:"conclusion"`
+	PullRequests []struct {
+		Number int `json:"number"`
+	} `json:"pull_requests"`
+	App struct {
+		Name string `json:"name"`
+	} `json:"app"`
+}
+
+// githubPullRequest is the payload of the GitHub pull_request event.
+type githubPullRequest struct {
+	Action      string `json:"action"`
+	PullRequest struct {
+		Number         int    `json:"number"`
+		Title          string `json:"title"`
+		Merged         bool   `json:"merged"`
+		MergeCommitSHA string `json:"merge_commit_sha"`
+		User           struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+		Labels []struct {
+			Name string `json:"name"`
+		} `json:"labels"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		SSHURL   string `json:"ssh_url"`
+		CloneURL string `json:"clone_url"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// githubMaxCommits is the number of commits above which GitHub may
+// leave commits or their files out of a push event.
+const githubMaxCommits = 20
+
+// githubCommit is a commit of a GitHub push event.
+type githubCommit struct {
+	ID       string   `json:"id"`
+	Message  string   `json:"message"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+	Author   struct {
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+	} `json:"author"`
+}
+
+// GitHub is the provider of the GitHub webhooks, it is also the fallback
+// for any request that no registered provider detects.
+type GitHub struct{}
+
+// Name returns the name of the provider.
+func (GitHub) Name() string {
+	return ProviderGitHub
+}
+
+// Detect reports whether the headers belong to a GitHub request.
+func (GitHub) Detect(headers http.Header) bool {
+	return headers.Get("X-GitHub-Event") != ""
+}
+
+// Verify checks the X-Hub-Signature-256 header, or the X-Hub-Signature
+// header when the former is missing.
+func (GitHub) Verify(headers http.Header, body []byte, secret string) bool {
+	if signature := headers.Get("X-Hub-Signature-256"); signature != "" {
+		expected := "sha256=" + common.Sha256Hmac(string(body), secret)
+		return hmac.Equal([]byte(signature), []byte(expected))
+	}
+	signature := strings.TrimPrefix(headers.Get("X-Hub-Signature"), "sha1=")
+	return signature != "" &&
+		hmac.Equal([]byte(signature), []byte(common.Sha1Hmac(string(body), secret)))
+}
+
+// Delivery returns the X-GitHub-Delivery ID of the request.
+func (GitHub) Delivery(headers http.Header) string {
+	return headers.Get("X-GitHub-Delivery")
+}
+
+// Parse parses a GitHub push, workflow_run, check_suite or pull_request
+// payload, sent either as JSON or as the "payload" field of a form, to
+// an event.
+func (GitHub) Parse(headers http.Header, body []byte) (Event, error) {
+	payload, err := githubPayload(headers, body)
+	if err != nil {
+		return Event{}, err
+	}
+	eventType := headers.Get("X-GitHub-Event")
+	switch eventType {
+	case "":
+		eventType = EventPush
+	case EventWorkflowRun, EventCheckSuite:
+		return parseGitHubCheckRun(eventType, payload)
+	case EventPullRequest:
+		return parseGitHubPullRequest(payload)
+	}
+	var p githubPush
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal request body to json: %v", err)
+	}
+	event := Event{
+		Provider: ProviderGitHub,
+		Type:     eventType,
+		Repository: Repository{
+			FullName: p.Repository.FullName,
+			SSHURL:   p.Repository.SSHURL,
+			CloneURL: p.Repository.CloneURL,
+		},
+		Ref:    p.Ref,
+		Before: p.Before,
+		After:  p.After,
+		Pusher: User{
+			Username: p.Pusher.Name,
+			Email:    p.Pusher.Email,
+		},
+		Sender: User{Username: p.Sender.Login},
+	}
+	for _, c := range p.Commits {
+		event.Commits = append(event.Commits, Commit{
+			ID:      c.ID,
+			Message: c.Message,
+			Author: User{
+				Name:     c.Author.Name,
+				Username: c.Author.Username,
+				Email:    c.Author.Email,
+			},
+			Added:    c.Added,
+			Modified: c.Modified,
+			Removed:  c.Removed,
+		})
+	}
+	event.Truncated = len(p.Commits) >= githubMaxCommits
+	return event, nil
+}
+
+// parseGitHubCheckRun parses a workflow_run or check_suite payload to
+// an event for the head commit of the run. Runs of pull requests, which
+// may come from forks with a branch of the same name, get no reference
+// so that they never match a deployment: workflow runs must be triggered
+// by a push or come from the repository itself, and check suites must
+// have a head branch and no pull requests.
+func parseGitHubCheckRun(eventType string, payload []byte) (Event, error) {
+	var p githubCheckRun
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal request body to json: %v", err)
+	}
+	event := Event{
+		Provider: ProviderGitHub,
+		Type:     eventType,
+		Action:   p.Action,
+		Repository: Repository{
+			FullName: p.Repository.FullName,
+			SSHURL:   p.Repository.SSHURL,
+			CloneURL: p.Repository.CloneURL,
+		},
+		Sender: User{Username: p.Sender.Login},
+	}
+	switch {
+	case p.WorkflowRun != nil:
+		run := p.WorkflowRun
+		event.Workflow = run.Name
+		if run.HeadBranch != "" && (run.Event == EventPush ||
+			run.HeadRepository.FullName != "" && run.HeadRepository.FullName == p.Repository.FullName) {
+			event.Ref = "refs/heads/" + run.HeadBranch
+		}
+		event.After = run.HeadSHA
+		event.Conclusion = run.Conclusion
+	case p.CheckSuite != nil:
+		suite := p.CheckSuite
+		event.Workflow = suite.App.Name
+		if suite.HeadBranch != nil && *suite.HeadBranch != "" && len(suite.PullRequests) == 0 {
+			event.Ref = "refs/heads/" + *suite.HeadBranch
+		}
+		event.After = suite.HeadSHA
+		event.Conclusion = suite.Conclusion
+	default:
+		return Event{}, fmt.Errorf("%v event without a run", eventType)
+	}
+	return event, nil
+}
+
+// parseGitHubPullRequest parses a pull_request payload to an event for
+// the base branch and the merge commit of the pull request.
+func parseGitHubPullRequest(payload []byte) (Event, error) {
+	var p githubPullRequest
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal request body to json: %v", err)
+	}
+	event := Event{
+		Provider: ProviderGitHub,
+		Type:     EventPullRequest,
+		Action:   p.Action,
+		Repository: Repository{
+			FullName: p.Repository.FullName,
+			SSHURL:   p.Repository.SSHURL,
+			CloneURL: p.Repository.CloneURL,
+		},
+		Ref:    "refs/heads/" + p.PullRequest.Base.Ref,
+		After:  p.PullRequest.MergeCommitSHA,
+		Sender: User{Username: p.Sender.Login},
+		PullRequest: PullRequest{
+			Number: p.PullRequest.Number,
+			Title:  p.PullRequest.Title,
+			Author: p.PullRequest.User.Login,
+			Merged: p.PullRequest.Merged,
+		},
+	}
+	for _, l := range p.PullRequest.Labels {
+		event.PullRequest.Labels = append(event.PullRequest.Labels, l.Name)
+	}
+	return event, nil
+}
+
+// githubPayload returns the JSON payload of a GitHub request body.
+func githubPayload(headers http.Header, body []byte) ([]byte, error) {
+	switch mediaType(headers) {
+	case ApplicationJSON:
+		return body, nil
+	case ApplicationFormURLEncoded:
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("parse form body: %v", err)
+		}
+		payload := form.Get("payload")
+		if payload == "" {
+			return nil, errors.New("form body without a payload field")
+		}
+		return []byte(payload), nil
+	default:
+		return nil, ErrUnsupportedContentType
+	}
+}
@@ -0,0 +1,116 @@
+package request
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// gitlabPush is the payload of the GitLab push and tag push events.
+type gitlabPush struct {
+	ObjectKind   string `json:"object_kind"`
+	Ref          string `json:"ref"`
+	Before       string `json:"before"`
+	After        string `json:"after"`
+	CheckoutSHA  string `json:"checkout_sha"`
+	UserName     string `json:"user_name"`
+	UserUsername string `json:"user_username"`
+	UserEmail    string `json:"user_email"`
+	Project      struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitSSHURL         string `json:"git_ssh_url"`
+		GitHTTPURL        string `json:"git_http_url"`
+	} `json:"project"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+	TotalCommitsCount int `json:"total_commits_count"`
+}
+
+// GitLab is the provider of the GitLab webhooks.
+type GitLab struct{}
+
+// Name returns the name of the provider.
+func (GitLab) Name() string {
+	return ProviderGitLab
+}
+
+// Detect reports whether the headers belong to a GitLab request.
+func (GitLab) Detect(headers http.Header) bool {
+	return headers.Get("X-Gitlab-Event") != ""
+}
+
+// Verify checks the X-Gitlab-Token header, which carries the secret
+// itself instead of a signature.
+func (GitLab) Verify(headers http.Header, body []byte, secret string) bool {
+	token := headers.Get("X-Gitlab-Token")
+	return token != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// Delivery returns the X-Gitlab-Event-UUID of the request.
+func (GitLab) Delivery(headers http.Header) string {
+	return headers.Get("X-Gitlab-Event-UUID")
+}
+
+// Parse parses a GitLab push or tag push payload to an event.
+func (GitLab) Parse(headers http.Header, body []byte) (Event, error) {
+	if !isJSON(headers) {
+		return Event{}, ErrUnsupportedContentType
+	}
+	var p gitlabPush
+	if err := json.Unmarshal(body, &p); err != nil {
+		return Event{}, fmt.Errorf("unmarshal gitlab body to json: %v", err)
+	}
+	if p.ObjectKind != "push" && p.ObjectKind != "tag_push" {
+		return Event{}, fmt.Errorf("unsupported gitlab event %q", p.ObjectKind)
+	}
+	after := p.CheckoutSHA
+	if after == "" {
+		after = p.After
+	}
+	event := Event{
+		Provider: ProviderGitLab,
+		Type:     p.ObjectKind,
+		Repository: Repository{
+			FullName: p.Project.PathWithNamespace,
+			SSHURL:   p.Project.GitSSHURL,
+			CloneURL: p.Project.GitHTTPURL,
+		},
+		Ref:    p.Ref,
+		Before: p.Before,
+		After:  after,
+		Pusher: User{
+			Name:     p.UserName,
+			Username: p.UserUsername,
+			Email:    p.UserEmail,
+		},
+	}
+	// GitLab only sends the user that pushed.
+	event.Sender = event.Pusher
+	for _, c := range p.Commits {
+		event.Commits = append(event.Commits, Commit{
+			ID:      c.ID,
+			Message: c.Message,
+			Author: User{
+				Name:  c.Author.Name,
+				Email: c.Author.Email,
+			},
+			Added:    c.Added,
+			Modified: c.Modified,
+			Removed:  c.Removed,
+		})
+	}
+	// GitLab only sends the first 20 commits of a push.
+	event.Truncated = p.TotalCommitsCount > len(p.Commits)
+	return event, nil
+}
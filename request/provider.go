@@ -0,0 +1,96 @@
+package request
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"sync"
+)
+
+// The content types of the webhook bodies.
+const (
+	ApplicationJSON           string = "application/json"
+	ApplicationFormURLEncoded string = "application/x-www-form-urlencoded"
+)
+
+// ErrUnsupportedContentType is returned by providers for request bodies
+// with a content type they cannot parse.
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// Provider is a source of webhooks, e.g. a git forge.
+type Provider interface {
+	// Name returns the name of the provider, e.g. "github".
+	Name() string
+	// Detect reports whether the headers belong to a request of
+	// the provider.
+	Detect(headers http.Header) bool
+	// Verify checks the signature or token of the raw body against
+	// the secret of a deployment.
+	Verify(headers http.Header, body []byte, secret string) bool
+	// Parse parses the raw body into a normalized event.
+	Parse(headers http.Header, body []byte) (Event, error)
+}
+
+// Deliverer is implemented by the providers that send a unique ID with
+// every delivery of a webhook, which is used to reject replays.
+type Deliverer interface {
+	// Delivery returns the ID of the delivery, or an empty string.
+	Delivery(headers http.Header) string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []Provider
+	// The provider of the requests that no provider detects, e.g.
+	// GitHub compatible requests without an X-GitHub-Event header.
+	fallback Provider = GitHub{}
+)
+
+func init() {
+	Register(GitLab{})
+	Register(Gitea{})
+	Register(Bitbucket{})
+	// Gitea also sends the X-GitHub-Event header, GitHub is registered
+	// last so that it only detects the requests of no other forge.
+	Register(GitHub{})
+}
+
+// Register adds a provider to the registry. Providers are consulted in
+// the order they were registered and GitHub is also used when none of
+// them detects a request.
+func Register(p Provider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, p)
+}
+
+// Detect returns the registered provider that detects the headers.
+func Detect(headers http.Header) Provider {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, p := range registry {
+		if p.Detect(headers) {
+			return p
+		}
+	}
+	return fallback
+}
+
+// mediaType returns the media type of the body, a body without any
+// content type is assumed to be JSON.
+func mediaType(headers http.Header) string {
+	contentType := headers.Get("Content-Type")
+	if contentType == "" {
+		return ApplicationJSON
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// isJSON reports whether the headers declare a JSON body.
+func isJSON(headers http.Header) bool {
+	return mediaType(headers) == ApplicationJSON
+}
@@ -1,8 +1,11 @@
 package request_test
 
 import (
+	"encoding/json"
+	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	request "github.com/klipitkas/hooktail/request"
 )
@@ -41,7 +44,7 @@ func TestRequestParse(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			req := request.Request{}
 			err := req.Parse([]byte(tt.args.body))
-			got := req.Body.Repository.SSHURL
+			got := req.Event.Repository.SSHURL
 			if (err != nil) != tt.wantErr {
 				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
 				return
@@ -133,3 +136,1343 @@ func TestRequestHash(t *testing.T) {
 		})
 	}
 }
+
+func TestRequestParseGitLab(t *testing.T) {
+
+	type args struct {
+		body string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    request.Event
+		wantErr bool
+	}{
+		{
+			"Parse valid gitlab push body",
+			args{
+				body: `{"object_kind":"push","event_name":"push","before":"95790bf891e76fee5e1747ab589903a6a1f80f22","after":"da1560886d4f094c3e6c9ef40349f7d38b5d27d7","ref":"refs/heads/master","checkout_sha":"da1560886d4f094c3e6c9ef40349f7d38b5d27d7","user_name":"John Smith","user_username":"jsmith","user_email":"john@example.com","project":{"name":"Diaspora","path_with_namespace":"mike/diaspora","git_ssh_url":"git@example.com:mike/diaspora.git","git_http_url":"http://example.com/mike/diaspora.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitLab,
+				Type:     "push",
+				Repository: request.Repository{
+					FullName: "mike/diaspora",
+					SSHURL:   "git@example.com:mike/diaspora.git",
+					CloneURL: "http://example.com/mike/diaspora.git",
+				},
+				Ref:    "refs/heads/master",
+				Before: "95790bf891e76fee5e1747ab589903a6a1f80f22",
+				After:  "da1560886d4f094c3e6c9ef40349f7d38b5d27d7",
+				Pusher: request.User{
+					Name:     "John Smith",
+					Username: "jsmith",
+					Email:    "john@example.com",
+				},
+				Sender: request.User{
+					Name:     "John Smith",
+					Username: "jsmith",
+					Email:    "john@example.com",
+				},
+			},
+			false,
+		},
+		{
+			"Parse valid gitlab tag push body",
+			args{
+				body: `{"object_kind":"tag_push","before":"0000000000000000000000000000000000000000","after":"82b3d5ae55f7080f1e6022629cdb57bfae7cccc7","ref":"refs/tags/v1.0.0","checkout_sha":"82b3d5ae55f7080f1e6022629cdb57bfae7cccc7","user_name":"John Smith","user_username":"jsmith","project":{"git_ssh_url":"git@example.com:jsmith/example.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitLab,
+				Type:     "tag_push",
+				Repository: request.Repository{
+					SSHURL: "git@example.com:jsmith/example.git",
+				},
+				Ref:    "refs/tags/v1.0.0",
+				Before: "0000000000000000000000000000000000000000",
+				After:  "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+				Pusher: request.User{
+					Name:     "John Smith",
+					Username: "jsmith",
+				},
+				Sender: request.User{
+					Name:     "John Smith",
+					Username: "jsmith",
+				},
+			},
+			false,
+		},
+		{
+			"Parse unsupported gitlab event should fail",
+			args{
+				body: `{"object_kind":"merge_request"}`,
+			},
+			request.Event{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = make(map[string][]string, 1)
+			req.Headers["X-Gitlab-Event"] = []string{"Push Hook"}
+			err := req.Parse([]byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(req.Event, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", req.Event, req.Event, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestHasValidGitLabToken(t *testing.T) {
+
+	type args struct {
+		token  string
+		secret string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			"Test that a matching gitlab token is valid",
+			args{
+				token:  "love",
+				secret: "love",
+			},
+			true,
+		},
+		{
+			"Test that a different gitlab token is invalid",
+			args{
+				token:  "hate",
+				secret: "love",
+			},
+			false,
+		},
+		{
+			"Test that a missing gitlab token is invalid",
+			args{
+				token:  "",
+				secret: "love",
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = make(map[string][]string, 2)
+			req.Headers["X-Gitlab-Event"] = []string{"Push Hook"}
+			req.Headers["X-Gitlab-Token"] = []string{tt.args.token}
+			got := req.HasValidSignature(tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestParseGitea(t *testing.T) {
+
+	type args struct {
+		eventHeader string
+		event       string
+		body        string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    request.Event
+		wantErr bool
+	}{
+		{
+			"Parse valid gitea push body",
+			args{
+				eventHeader: "X-Gitea-Event",
+				event:       "push",
+				body:        `{"ref":"refs/heads/main","before":"28e1879d029cb852e4844d9c718537df08844e03","after":"bffeb74224043ba2feb48d137756c8a9331c449a","repository":{"full_name":"gitea/webhooks","ssh_url":"git@localhost:gitea/webhooks.git","clone_url":"http://localhost:3000/gitea/webhooks.git"},"pusher":{"login":"gitea","full_name":"Gitea","email":"gitea@example.com","username":"gitea"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitea,
+				Type:     "push",
+				Repository: request.Repository{
+					FullName: "gitea/webhooks",
+					SSHURL:   "git@localhost:gitea/webhooks.git",
+					CloneURL: "http://localhost:3000/gitea/webhooks.git",
+				},
+				Ref:    "refs/heads/main",
+				Before: "28e1879d029cb852e4844d9c718537df08844e03",
+				After:  "bffeb74224043ba2feb48d137756c8a9331c449a",
+				Pusher: request.User{
+					Name:     "Gitea",
+					Username: "gitea",
+					Email:    "gitea@example.com",
+				},
+			},
+			false,
+		},
+		{
+			"Parse valid gogs push body",
+			args{
+				eventHeader: "X-Gogs-Event",
+				event:       "push",
+				body:        `{"ref":"refs/heads/master","before":"a","after":"b","repository":{"ssh_url":"git@gogs.example.com:unknwon/gogs.git"},"pusher":{"username":"unknwon","full_name":"Unknwon","email":"u@example.com"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitea,
+				Type:     "push",
+				Repository: request.Repository{
+					SSHURL: "git@gogs.example.com:unknwon/gogs.git",
+				},
+				Ref:    "refs/heads/master",
+				Before: "a",
+				After:  "b",
+				Pusher: request.User{
+					Name:     "Unknwon",
+					Username: "unknwon",
+					Email:    "u@example.com",
+				},
+			},
+			false,
+		},
+		{
+			"Parse unsupported gitea event should fail",
+			args{
+				eventHeader: "X-Gitea-Event",
+				event:       "issues",
+				body:        `{}`,
+			},
+			request.Event{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = make(map[string][]string, 1)
+			req.Headers[tt.args.eventHeader] = []string{tt.args.event}
+			err := req.Parse([]byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(req.Event, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", req.Event, req.Event, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestHasValidGiteaSignature(t *testing.T) {
+
+	type args struct {
+		header    string
+		signature string
+		secret    string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			"Test that a valid gitea signature is accepted",
+			args{
+				header:    "X-Gitea-Signature",
+				signature: "96f3032370082f8d5599a16fe8ed1856a493234b30ddf7aac158a67f8c63dbb6",
+				secret:    "love",
+			},
+			true,
+		},
+		{
+			"Test that a valid gogs signature is accepted",
+			args{
+				header:    "X-Gogs-Signature",
+				signature: "96f3032370082f8d5599a16fe8ed1856a493234b30ddf7aac158a67f8c63dbb6",
+				secret:    "love",
+			},
+			true,
+		},
+		{
+			"Test that a gitea signature with another secret is rejected",
+			args{
+				header:    "X-Gitea-Signature",
+				signature: "96f3032370082f8d5599a16fe8ed1856a493234b30ddf7aac158a67f8c63dbb6",
+				secret:    "hate",
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.JSONBody = `{"ref":"refs/heads/main"}`
+			req.Headers = make(map[string][]string, 2)
+			req.Headers["X-Gitea-Event"] = []string{"push"}
+			req.Headers[tt.args.header] = []string{tt.args.signature}
+			got := req.HasValidSignature(tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestParseBitbucket(t *testing.T) {
+
+	type args struct {
+		eventKey string
+		body     string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    request.Event
+		wantErr bool
+	}{
+		{
+			"Parse valid bitbucket cloud push body",
+			args{
+				eventKey: "repo:push",
+				body:     `{"push":{"changes":[{"new":{"type":"branch","name":"master","target":{"hash":"709d658dc5b6d6afcd46049c2f332ee3f515a67d"}},"old":{"type":"branch","name":"master","target":{"hash":"1e65c05c1d5171631d92438a13901ca7dae9618c"}}}]},"repository":{"full_name":"team/repo","links":{"html":{"href":"https://bitbucket.org/team/repo"}}},"actor":{"display_name":"Emma","nickname":"emma"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderBitbucket,
+				Type:     "push",
+				Repository: request.Repository{
+					FullName: "team/repo",
+					SSHURL:   "git@bitbucket.org:team/repo.git",
+					CloneURL: "https://bitbucket.org/team/repo.git",
+				},
+				Ref:    "refs/heads/master",
+				Before: "1e65c05c1d5171631d92438a13901ca7dae9618c",
+				After:  "709d658dc5b6d6afcd46049c2f332ee3f515a67d",
+				Pusher: request.User{
+					Name:     "Emma",
+					Username: "emma",
+				},
+				Sender: request.User{
+					Name:     "Emma",
+					Username: "emma",
+				},
+			},
+			false,
+		},
+		{
+			"Parse valid bitbucket server push body",
+			args{
+				eventKey: "repo:refs_changed",
+				body:     `{"eventKey":"repo:refs_changed","actor":{"name":"admin","emailAddress":"admin@example.com","displayName":"Administrator"},"repository":{"slug":"repo","links":{"clone":[{"href":"http://bitbucket.example.com/scm/proj/repo.git","name":"http"},{"href":"ssh://git@bitbucket.example.com:7999/proj/repo.git","name":"ssh"}]}},"changes":[{"ref":{"id":"refs/tags/v1.0.0","displayId":"v1.0.0","type":"TAG"},"refId":"refs/tags/v1.0.0","fromHash":"0000000000000000000000000000000000000000","toHash":"a00945762949b7787ecabc388c0e20b1b85f0b2a","type":"ADD"}]}`,
+			},
+			request.Event{
+				Provider: request.ProviderBitbucket,
+				Type:     "tag_push",
+				Repository: request.Repository{
+					SSHURL:   "ssh://git@bitbucket.example.com:7999/proj/repo.git",
+					CloneURL: "http://bitbucket.example.com/scm/proj/repo.git",
+				},
+				Ref:    "refs/tags/v1.0.0",
+				Before: "0000000000000000000000000000000000000000",
+				After:  "a00945762949b7787ecabc388c0e20b1b85f0b2a",
+				Pusher: request.User{
+					Name:     "Administrator",
+					Username: "admin",
+					Email:    "admin@example.com",
+				},
+				Sender: request.User{
+					Name:     "Administrator",
+					Username: "admin",
+					Email:    "admin@example.com",
+				},
+			},
+			false,
+		},
+		{
+			"Parse bitbucket push that only deletes a branch should fail",
+			args{
+				eventKey: "repo:push",
+				body:     `{"push":{"changes":[{"new":null,"old":{"type":"branch","name":"feature"}}]}}`,
+			},
+			request.Event{},
+			true,
+		},
+		{
+			"Parse unsupported bitbucket event should fail",
+			args{
+				eventKey: "diagnostics:ping",
+				body:     `{"test":true}`,
+			},
+			request.Event{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = make(map[string][]string, 1)
+			req.Headers["X-Event-Key"] = []string{tt.args.eventKey}
+			err := req.Parse([]byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(req.Event, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", req.Event, req.Event, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestHasValidBitbucketSignature(t *testing.T) {
+
+	type args struct {
+		signature string
+		secret    string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			"Test that a valid bitbucket signature is accepted",
+			args{
+				signature: "sha256=d4afe5e82fef2c2cde008f6c7b64060cfa154bff29b99b269b610903232ee6c4",
+				secret:    "love",
+			},
+			true,
+		},
+		{
+			"Test that a bitbucket signature without the prefix is rejected",
+			args{
+				signature: "d4afe5e82fef2c2cde008f6c7b64060cfa154bff29b99b269b610903232ee6c4",
+				secret:    "love",
+			},
+			false,
+		},
+		{
+			"Test that a bitbucket signature with another secret is rejected",
+			args{
+				signature: "sha256=d4afe5e82fef2c2cde008f6c7b64060cfa154bff29b99b269b610903232ee6c4",
+				secret:    "hate",
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.JSONBody = `{"eventKey":"repo:refs_changed"}`
+			req.Headers = make(map[string][]string, 2)
+			req.Headers["X-Event-Key"] = []string{"repo:refs_changed"}
+			req.Headers["X-Hub-Signature"] = []string{tt.args.signature}
+			got := req.HasValidSignature(tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+
+	type args struct {
+		headers http.Header
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			"Detect a github request",
+			args{
+				headers: http.Header{"X-Github-Event": {"push"}},
+			},
+			request.ProviderGitHub,
+		},
+		{
+			"Detect a gitlab request",
+			args{
+				headers: http.Header{"X-Gitlab-Event": {"Push Hook"}},
+			},
+			request.ProviderGitLab,
+		},
+		{
+			"Detect a gitea request",
+			args{
+				headers: http.Header{"X-Gitea-Event": {"push"}},
+			},
+			request.ProviderGitea,
+		},
+		{
+			"Detect a gitea request with the github headers",
+			args{
+				headers: http.Header{"X-Gitea-Event": {"push"}, "X-Github-Event": {"push"}},
+			},
+			request.ProviderGitea,
+		},
+		{
+			"Detect a bitbucket request",
+			args{
+				headers: http.Header{"X-Event-Key": {"repo:push"}},
+			},
+			request.ProviderBitbucket,
+		},
+		{
+			"Detect a request without any known header as github",
+			args{
+				headers: http.Header{"X-Unknown": {"push"}},
+			},
+			request.ProviderGitHub,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := request.Detect(tt.args.headers).Name()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestParseContentType(t *testing.T) {
+
+	type args struct {
+		contentType string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		wantErr error
+	}{
+		{
+			"Parse a json body",
+			args{
+				contentType: "application/json",
+			},
+			nil,
+		},
+		{
+			"Parse a json body with a charset",
+			args{
+				contentType: "application/json; charset=utf-8",
+			},
+			nil,
+		},
+		{
+			"Parse a plain text body should fail",
+			args{
+				contentType: "text/plain",
+			},
+			request.ErrUnsupportedContentType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = http.Header{}
+			req.Headers["Content-Type"] = []string{tt.args.contentType}
+			err := req.Parse([]byte(`{"ref":"refs/heads/master"}`))
+			if err != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequestParseGitHubForm(t *testing.T) {
+
+	type args struct {
+		body string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			"Parse valid form encoded request body",
+			args{
+				body: `payload=%7B%22ref%22%3A%22refs%2Fheads%2Fmaster%22%2C%22repository%22%3A%7B%22ssh_url%22%3A%22git%40github.com%3Aklipitkas%2Fhooktail.git%22%7D%7D`,
+			},
+			"git@github.com:klipitkas/hooktail.git",
+			false,
+		},
+		{
+			"Parse form encoded request body without payload should fail",
+			args{
+				body: `ref=refs%2Fheads%2Fmaster`,
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = http.Header{}
+			req.Headers["Content-Type"] = []string{"application/x-www-form-urlencoded"}
+			err := req.Parse([]byte(tt.args.body))
+			got := req.Event.Repository.SSHURL
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestHasValidFormSignature(t *testing.T) {
+
+	type args struct {
+		secret string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			"Test that the signature over the raw form body is valid",
+			args{
+				secret: "love",
+			},
+			true,
+		},
+		{
+			"Test that the signature with another secret is invalid",
+			args{
+				secret: "hate",
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.JSONBody = `payload=%7B%22ref%22%3A%22refs%2Fheads%2Fmaster%22%2C%22repository%22%3A%7B%22ssh_url%22%3A%22git%40github.com%3Aklipitkas%2Fhooktail.git%22%7D%7D`
+			req.Headers = http.Header{}
+			req.Headers["Content-Type"] = []string{"application/x-www-form-urlencoded"}
+			req.Headers["X-Hub-Signature"] = []string{"sha1=027725786951f359d1f28429e08479200b7d6bf4"}
+			got := req.HasValidSignature(tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+
+	type args struct {
+		payload string
+		path    string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{
+			"Lookup a nested field",
+			args{
+				payload: `{"repository":{"name":"hooktail"}}`,
+				path:    "repository.name",
+			},
+			"hooktail",
+			false,
+		},
+		{
+			"Lookup an array element with a jsonpath expression",
+			args{
+				payload: `{"commits":[{"id":"a"},{"id":"b"}]}`,
+				path:    "$.commits[1].id",
+			},
+			"b",
+			false,
+		},
+		{
+			"Lookup an array element with a dotted index",
+			args{
+				payload: `{"commits":[{"id":"a"},{"id":"b"}]}`,
+				path:    "commits.0.id",
+			},
+			"a",
+			false,
+		},
+		{
+			"Lookup a field that is not a string",
+			args{
+				payload: `{"build":{"number":42,"passed":true}}`,
+				path:    "build.number",
+			},
+			"42",
+			false,
+		},
+		{
+			"Lookup a missing field should fail",
+			args{
+				payload: `{"repository":{"name":"hooktail"}}`,
+				path:    "repository.owner",
+			},
+			"",
+			true,
+		},
+		{
+			"Lookup an index out of range should fail",
+			args{
+				payload: `{"commits":[]}`,
+				path:    "commits[0]",
+			},
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload interface{}
+			if err := json.Unmarshal([]byte(tt.args.payload), &payload); err != nil {
+				t.Errorf("unmarshal payload failed %v", err)
+				return
+			}
+			got, err := request.Lookup(payload, tt.args.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericParse(t *testing.T) {
+
+	type args struct {
+		generic request.Generic
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    request.Event
+		wantErr bool
+	}{
+		{
+			"Parse a generic body with matching conditions",
+			args{
+				generic: request.Generic{
+					Repository: "project.repo",
+					Ref:        "build.branch",
+					SHA:        "$.build.commit",
+					Match:      map[string]string{"build.status": "success"},
+				},
+			},
+			request.Event{
+				Provider: request.ProviderGeneric,
+				Type:     request.ProviderGeneric,
+				Repository: request.Repository{
+					SSHURL:   "git@ci.example.com:team/app.git",
+					CloneURL: "git@ci.example.com:team/app.git",
+				},
+				Ref:   "main",
+				After: "abc123",
+			},
+			false,
+		},
+		{
+			"Parse a generic body with failing conditions should fail",
+			args{
+				generic: request.Generic{
+					Repository: "project.repo",
+					Match:      map[string]string{"build.status": "failure"},
+				},
+			},
+			request.Event{},
+			true,
+		},
+		{
+			"Parse a generic body with a missing field should fail",
+			args{
+				generic: request.Generic{
+					Repository: "project.url",
+				},
+			},
+			request.Event{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.args.generic.Parse(http.Header{}, []byte(`{"project":{"repo":"git@ci.example.com:team/app.git"},"build":{"status":"success","branch":"main","commit":"abc123"}}`))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericVerify(t *testing.T) {
+
+	type args struct {
+		generic request.Generic
+		headers map[string]string
+		secret  string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			"Test a valid signature in a custom header",
+			args{
+				generic: request.Generic{
+					SignatureHeader: "X-CI-Signature",
+					Algorithm:       "sha512",
+				},
+				headers: map[string]string{"X-CI-Signature": "dbd075557268cd84a837369bf5b385f89ccc2b29bdf4cd6f65daa6eeddacd7bfbe8b72aa6c2c1ff7bd88e6cb8c1fd71cb863cc1d8ac7891437fbbffd3762e78a"},
+				secret:  "love",
+			},
+			true,
+		},
+		{
+			"Test a valid signature with a prefix",
+			args{
+				generic: request.Generic{
+					SignatureHeader: "X-CI-Signature",
+					Algorithm:       "sha512",
+					SignaturePrefix: "v1=",
+				},
+				headers: map[string]string{"X-CI-Signature": "v1=dbd075557268cd84a837369bf5b385f89ccc2b29bdf4cd6f65daa6eeddacd7bfbe8b72aa6c2c1ff7bd88e6cb8c1fd71cb863cc1d8ac7891437fbbffd3762e78a"},
+				secret:  "love",
+			},
+			true,
+		},
+		{
+			"Test a signature with another secret is invalid",
+			args{
+				generic: request.Generic{
+					SignatureHeader: "X-CI-Signature",
+					Algorithm:       "sha512",
+				},
+				headers: map[string]string{"X-CI-Signature": "dbd075557268cd84a837369bf5b385f89ccc2b29bdf4cd6f65daa6eeddacd7bfbe8b72aa6c2c1ff7bd88e6cb8c1fd71cb863cc1d8ac7891437fbbffd3762e78a"},
+				secret:  "hate",
+			},
+			false,
+		},
+		{
+			"Test a valid bearer token",
+			args{
+				generic: request.Generic{
+					Token: "static-token",
+				},
+				headers: map[string]string{"Authorization": "Bearer static-token"},
+			},
+			true,
+		},
+		{
+			"Test an invalid bearer token",
+			args{
+				generic: request.Generic{
+					Token: "static-token",
+				},
+				headers: map[string]string{"Authorization": "Bearer other-token"},
+			},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for k, v := range tt.args.headers {
+				headers.Set(k, v)
+			}
+			got := tt.args.generic.Verify(headers, []byte(`{"project":{"repo":"git@ci.example.com:team/app.git"},"build":{"status":"success","branch":"main","commit":"abc123"}}`), tt.args.secret)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestParseGitHubWorkflowRun(t *testing.T) {
+
+	type args struct {
+		event string
+		body  string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    request.Event
+		wantErr bool
+	}{
+		{
+			"Parse valid workflow run body",
+			args{
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"CI","event":"push","head_branch":"master","head_sha":"5979ddf50f80eece2af7ccaca21fcb776cbade3b","conclusion":"success","head_repository":{"full_name":"klipitkas/hooktail"}},"repository":{"full_name":"klipitkas/hooktail","ssh_url":"git@github.com:klipitkas/hooktail.git"},"sender":{"login":"klipitkas"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "workflow_run",
+				Action:   "completed",
+				Repository: request.Repository{
+					FullName: "klipitkas/hooktail",
+					SSHURL:   "git@github.com:klipitkas/hooktail.git",
+				},
+				Ref:        "refs/heads/master",
+				After:      "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Sender:     request.User{Username: "klipitkas"},
+				Workflow:   "CI",
+				Conclusion: "success",
+			},
+			false,
+		},
+		{
+			"Parse valid check suite body",
+			args{
+				event: "check_suite",
+				body:  `{"action":"completed","check_suite":{"head_branch":"master","head_sha":"5979ddf50f80eece2af7ccaca21fcb776cbade3b","conclusion":"failure","app":{"name":"GitHub Actions"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "check_suite",
+				Action:   "completed",
+				Repository: request.Repository{
+					SSHURL: "git@github.com:klipitkas/hooktail.git",
+				},
+				Ref:        "refs/heads/master",
+				After:      "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Workflow:   "GitHub Actions",
+				Conclusion: "failure",
+			},
+			false,
+		},
+		{
+			"Parse workflow run body of a fork pull request without a reference",
+			args{
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"CI","event":"pull_request","head_branch":"master","head_sha":"5979ddf50f80eece2af7ccaca21fcb776cbade3b","conclusion":"success","head_repository":{"full_name":"fork/hooktail"}},"repository":{"full_name":"klipitkas/hooktail","ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "workflow_run",
+				Action:   "completed",
+				Repository: request.Repository{
+					FullName: "klipitkas/hooktail",
+					SSHURL:   "git@github.com:klipitkas/hooktail.git",
+				},
+				After:      "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Workflow:   "CI",
+				Conclusion: "success",
+			},
+			false,
+		},
+		{
+			"Parse workflow run body of a pull request of the repository",
+			args{
+				event: "workflow_run",
+				body:  `{"action":"completed","workflow_run":{"name":"CI","event":"pull_request","head_branch":"feature","head_sha":"5979ddf50f80eece2af7ccaca21fcb776cbade3b","conclusion":"success","head_repository":{"full_name":"klipitkas/hooktail"}},"repository":{"full_name":"klipitkas/hooktail","ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "workflow_run",
+				Action:   "completed",
+				Repository: request.Repository{
+					FullName: "klipitkas/hooktail",
+					SSHURL:   "git@github.com:klipitkas/hooktail.git",
+				},
+				Ref:        "refs/heads/feature",
+				After:      "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Workflow:   "CI",
+				Conclusion: "success",
+			},
+			false,
+		},
+		{
+			"Parse check suite body of a pull request without a reference",
+			args{
+				event: "check_suite",
+				body:  `{"action":"completed","check_suite":{"head_branch":"master","head_sha":"5979ddf50f80eece2af7ccaca21fcb776cbade3b","conclusion":"success","pull_requests":[{"number":7}],"app":{"name":"GitHub Actions"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "check_suite",
+				Action:   "completed",
+				Repository: request.Repository{
+					SSHURL: "git@github.com:klipitkas/hooktail.git",
+				},
+				After:      "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Workflow:   "GitHub Actions",
+				Conclusion: "success",
+			},
+			false,
+		},
+		{
+			"Parse check suite body without a head branch without a reference",
+			args{
+				event: "check_suite",
+				body:  `{"action":"completed","check_suite":{"head_branch":null,"head_sha":"5979ddf50f80eece2af7ccaca21fcb776cbade3b","conclusion":"success","app":{"name":"GitHub Actions"}},"repository":{"ssh_url":"git@github.com:klipitkas/hooktail.git"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "check_suite",
+				Action:   "completed",
+				Repository: request.Repository{
+					SSHURL: "git@github.com:klipitkas/hooktail.git",
+				},
+				After:      "5979ddf50f80eece2af7ccaca21fcb776cbade3b",
+				Workflow:   "GitHub Actions",
+				Conclusion: "success",
+			},
+			false,
+		},
+		{
+			"Parse workflow run body without a run should fail",
+			args{
+				event: "workflow_run",
+				body:  `{"action":"completed"}`,
+			},
+			request.Event{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = http.Header{}
+			req.Headers["X-Github-Event"] = []string{tt.args.event}
+			err := req.Parse([]byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(req.Event, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", req.Event, req.Event, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestParseGitHubPullRequest(t *testing.T) {
+
+	type args struct {
+		body string
+	}
+
+	tests := []struct {
+		name    string
+		args    args
+		want    request.Event
+		wantErr bool
+	}{
+		{
+			"Parse valid merged pull request body",
+			args{
+				body: `{"action":"closed","number":7,"pull_request":{"number":7,"title":"Add feature","merged":true,"merge_commit_sha":"e5bd3914e2e596debea16f433f57875b5b90bcd6","user":{"login":"octocat"},"base":{"ref":"master"},"labels":[{"name":"deploy"}]},"repository":{"full_name":"klipitkas/hooktail","ssh_url":"git@github.com:klipitkas/hooktail.git"},"sender":{"login":"klipitkas"}}`,
+			},
+			request.Event{
+				Provider: request.ProviderGitHub,
+				Type:     "pull_request",
+				Action:   "closed",
+				Repository: request.Repository{
+					FullName: "klipitkas/hooktail",
+					SSHURL:   "git@github.com:klipitkas/hooktail.git",
+				},
+				Ref:    "refs/heads/master",
+				After:  "e5bd3914e2e596debea16f433f57875b5b90bcd6",
+				Sender: request.User{Username: "klipitkas"},
+				PullRequest: request.PullRequest{
+					Number: 7,
+					Title:  "Add feature",
+					Author: "octocat",
+					Merged: true,
+					Labels: []string{"deploy"},
+				},
+			},
+			false,
+		},
+		{
+			"Parse invalid pull request body should fail",
+			args{
+				body: `{"pull_request":[]}`,
+			},
+			request.Event{},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := request.Request{}
+			req.Headers = http.Header{}
+			req.Headers["X-Github-Event"] = []string{"pull_request"}
+			err := req.Parse([]byte(tt.args.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(req.Event, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", req.Event, req.Event, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventFiles(t *testing.T) {
+
+	type args struct {
+		event request.Event
+	}
+
+	tests := []struct {
+		name         string
+		args         args
+		want         []string
+		wantComplete bool
+	}{
+		{
+			"Test the changed files of all the commits",
+			args{
+				event: request.Event{
+					Commits: []request.Commit{
+						{Added: []string{"a.go"}, Modified: []string{"b.go"}},
+						{Modified: []string{"b.go"}, Removed: []string{"c.go"}},
+					},
+				},
+			},
+			[]string{"a.go", "b.go", "c.go"},
+			true,
+		},
+		{
+			"Test the changed files of a truncated event are incomplete",
+			args{
+				event: request.Event{
+					Commits:   []request.Commit{{Added: []string{"a.go"}}},
+					Truncated: true,
+				},
+			},
+			nil,
+			false,
+		},
+		{
+			"Test the changed files of commits without files are incomplete",
+			args{
+				event: request.Event{
+					Commits: []request.Commit{{ID: "abc"}},
+				},
+			},
+			nil,
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, complete := tt.args.event.Files()
+			if complete != tt.wantComplete {
+				t.Errorf("complete = %v, wantComplete = %v", complete, tt.wantComplete)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got = %+v (%T), want = %+v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestDelivery(t *testing.T) {
+
+	type args struct {
+		provider request.Provider
+		headers  map[string]string
+	}
+
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{
+			"Test a GitHub delivery",
+			args{
+				provider: request.GitHub{},
+				headers:  map[string]string{"X-GitHub-Delivery": "72d3162e-cc78-11e3-81ab-4c9367dc0958"},
+			},
+			"72d3162e-cc78-11e3-81ab-4c9367dc0958",
+		},
+		{
+			"Test a GitLab delivery",
+			args{
+				provider: request.GitLab{},
+				headers:  map[string]string{"X-Gitlab-Event-UUID": "13792a34-cac6-4fda-95a8-c58e00a3954e"},
+			},
+			"13792a34-cac6-4fda-95a8-c58e00a3954e",
+		},
+		{
+			"Test a Gogs delivery",
+			args{
+				provider: request.Gitea{},
+				headers:  map[string]string{"X-Gogs-Delivery": "f6266f16-1bf3-46a5-9ea4-602e06ead473"},
+			},
+			"f6266f16-1bf3-46a5-9ea4-602e06ead473",
+		},
+		{
+			"Test a Bitbucket Server delivery",
+			args{
+				provider: request.Bitbucket{},
+				headers:  map[string]string{"X-Request-Id": "d2c6a7c5-3c43-4a8f-bb4f-2c3c0d6f39a1"},
+			},
+			"d2c6a7c5-3c43-4a8f-bb4f-2c3c0d6f39a1",
+		},
+		{
+			"Test a generic delivery header",
+			args{
+				provider: request.Generic{DeliveryHeader: "X-Build-Id"},
+				headers:  map[string]string{"X-Build-Id": "42"},
+			},
+			"42",
+		},
+		{
+			"Test a generic webhook without a delivery header",
+			args{
+				provider: request.Generic{},
+				headers:  map[string]string{"X-Build-Id": "42"},
+			},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for k, v := range tt.args.headers {
+				headers.Set(k, v)
+			}
+			if got := tt.args.provider.(request.Deliverer).Delivery(headers); got != tt.want {
+				t.Errorf("got = %q, want = %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventTimestamp(t *testing.T) {
+
+	sent := time.Date(2017, 9, 19, 9, 58, 11, 0, time.FixedZone("", 10*60*60))
+
+	tests := []struct {
+		name     string
+		provider request.Provider
+		headers  map[string]string
+		body     string
+		want     time.Time
+	}{
+		{
+			"Parse the date of a Bitbucket Server push",
+			request.Bitbucket{},
+			map[string]string{"X-Event-Key": "repo:refs_changed"},
+			`{"date":"2017-09-19T09:58:11+1000","changes":[{"ref":{"id":"refs/heads/master","type":"BRANCH"},"toHash":"abc123","type":"UPDATE"}]}`,
+			sent,
+		},
+		{
+			"Parse a generic RFC 3339 timestamp",
+			request.Generic{Timestamp: "sent_at"},
+			nil,
+			`{"sent_at":"2017-09-19T09:58:11+10:00"}`,
+			sent,
+		},
+		{
+			"Parse a generic unix timestamp",
+			request.Generic{Timestamp: "sent_at"},
+			nil,
+			`{"sent_at":1505779091}`,
+			sent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for k, v := range tt.headers {
+				headers.Set(k, v)
+			}
+			event, err := tt.provider.Parse(headers, []byte(tt.body))
+			if err != nil {
+				t.Errorf("error = %v", err)
+				return
+			}
+			if !event.Timestamp.Equal(tt.want) {
+				t.Errorf("got = %v, want = %v", event.Timestamp, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenericValidate(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		generic request.Generic
+		wantErr bool
+	}{
+		{"Validate a generic webhook with a repository", request.Generic{Repository: "project.repo"}, false},
+		{"Validate a generic webhook with a condition", request.Generic{Match: map[string]string{"status": "success"}}, false},
+		{"Validate a generic webhook that matches anything should fail", request.Generic{}, true},
+		{"Validate an unsupported algorithm should fail", request.Generic{Repository: "project.repo", Algorithm: "md5"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.generic.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr = %v", err, tt.wantErr)
+			}
+		})
+	}
+}